@@ -0,0 +1,205 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/daulet/cmd/config"
+	"github.com/daulet/cmd/parser"
+	"github.com/daulet/cmd/provider"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// DEFAULT_CHUNK_SIZE in tokens is used when context window of the model is unknown
+	DEFAULT_CHUNK_SIZE        = 4000
+	DEFAULT_CHUNK_CONCURRENCY = 4
+
+	MAP_TEMPLATE    = "This is part %d of %d of the input:\n\n%s\n\nApply the instruction to this part only: %s"
+	REDUCE_TEMPLATE = "The input was too large, so the instruction was applied to each part of it separately. Answers for each part, in order:\n\n%s\n\nMerge them into a single answer to the instruction: %s"
+	PART_TEMPLATE   = "Part %d:\n%s"
+)
+
+// chunkSize is max tokens of a chunk: configured size, otherwise quarter of
+// context window of the chat model, leaving room for the instruction and the answer.
+func chunkSize() int {
+	if cfg.ChunkSize > 0 {
+		return cfg.ChunkSize
+	}
+	if window := contextWindow(); window > 0 {
+		return window / 4
+	}
+	return DEFAULT_CHUNK_SIZE
+}
+
+// chatModel is the configured chat model, otherwise default model of the provider.
+func chatModel() string {
+	return cmp.Or(cfg.Model[config.ModelTypeChat], provider.DefaultModels(cfg)[config.ModelTypeChat])
+}
+
+// contextWindow of the chat model, zero when unknown.
+func contextWindow() int {
+	model := chatModel()
+	if model == "" {
+		return 0
+	}
+	info, err := cfg.LookupModel(model)
+	if err != nil {
+		return 0
+	}
+	return info.ContextWindow
+}
+
+// exceedsContext reports whether text won't fit into context window of the model.
+func exceedsContext(text string) bool {
+	window := contextWindow()
+	return window > 0 && provider.EstimateTokens(text) > window
+}
+
+// splitChunks splits text into chunks of at most size tokens, on paragraph
+// boundaries when possible, otherwise on lines, and only then mid line.
+func splitChunks(text string, size int) []string {
+	var (
+		chunks  []string
+		current strings.Builder
+	)
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+	add := func(piece string) {
+		if current.Len() > 0 && provider.EstimateTokens(current.String()+piece) > size {
+			flush()
+		}
+		current.WriteString(piece)
+	}
+	for _, paragraph := range strings.SplitAfter(text, "\n\n") {
+		if provider.EstimateTokens(paragraph) <= size {
+			add(paragraph)
+			continue
+		}
+		for _, line := range strings.SplitAfter(paragraph, "\n") {
+			if provider.EstimateTokens(line) <= size {
+				add(line)
+				continue
+			}
+			// longest piece that is still estimated at size tokens
+			for _, piece := range provider.SplitRunes(line, size*4-1) {
+				add(piece)
+			}
+		}
+	}
+	flush()
+	return chunks
+}
+
+// generateAll answers prompts concurrently, answers are in order of prompts.
+func generateAll(ctx context.Context, prompts []string) ([]string, error) {
+	concurrency := cfg.ChunkConcurrency
+	if concurrency <= 0 {
+		concurrency = DEFAULT_CHUNK_CONCURRENCY
+	}
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, concurrency)
+		answers  = make([]string, len(prompts))
+		mu       sync.Mutex
+		finished int
+		firstErr error
+	)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for i, prompt := range prompts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+			answer, err := generate(ctx, parser.MultiWriter(io.Discard), []*provider.Message{
+				{
+					Role:    provider.User,
+					Content: prompt,
+				},
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to process chunk %d: %w", i+1, err)
+				}
+				// no point in finishing other chunks
+				cancel()
+				return
+			}
+			answers[i] = answer
+			finished++
+			color.New(color.FgHiBlack).Fprintf(os.Stderr, "chunk %d/%d done\n", finished, len(prompts))
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return answers, nil
+}
+
+// reducePrompt merges partial answers into the prompt of the final request,
+// answers that don't fit together are merged in groups first.
+func reducePrompt(ctx context.Context, answers []string, instruction string) (string, error) {
+	parts := make([]string, len(answers))
+	for i, answer := range answers {
+		parts[i] = fmt.Sprintf(PART_TEMPLATE, i+1, strings.TrimSpace(answer))
+	}
+	merged := strings.Join(parts, "\n\n")
+	if len(answers) <= 1 || provider.EstimateTokens(merged) <= chunkSize() {
+		return fmt.Sprintf(REDUCE_TEMPLATE, merged, instruction), nil
+	}
+	groups := splitChunks(merged, chunkSize())
+	if len(groups) >= len(answers) {
+		// answers are too large to be grouped, so they are merged as is
+		return fmt.Sprintf(REDUCE_TEMPLATE, merged, instruction), nil
+	}
+	color.New(color.FgHiBlack).Fprintf(os.Stderr, "merging %d answers in %d groups\n", len(answers), len(groups))
+	merges := make([]string, len(groups))
+	for i, group := range groups {
+		merges[i] = fmt.Sprintf(REDUCE_TEMPLATE, group, instruction)
+	}
+	answers, err := generateAll(ctx, merges)
+	if err != nil {
+		return "", err
+	}
+	return reducePrompt(ctx, answers, instruction)
+}
+
+// mapReduce applies instruction to chunks of input and returns prompt to merge their answers.
+func mapReduce(ctx context.Context, input string, instruction string) (string, error) {
+	chunks := splitChunks(input, chunkSize())
+	color.New(color.FgHiBlack).Fprintf(os.Stderr, "input is split into %d chunks\n", len(chunks))
+	if len(chunks) == 1 {
+		return fmt.Sprintf(CONTEXT_TEMPLATE, input, instruction), nil
+	}
+	prompts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		prompts[i] = fmt.Sprintf(MAP_TEMPLATE, i+1, len(chunks), chunk, instruction)
+	}
+	answers, err := generateAll(ctx, prompts)
+	if err != nil {
+		return "", err
+	}
+	return reducePrompt(ctx, answers, instruction)
+}
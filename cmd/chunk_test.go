@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/daulet/cmd/config"
+	"github.com/daulet/cmd/provider"
+)
+
+func TestSplitChunks(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		size int
+		want int
+	}{
+		{name: "fits", text: "short text", size: 100, want: 1},
+		{name: "paragraphs", text: strings.Repeat("word ", 30) + "\n\n" + strings.Repeat("word ", 30), size: 50, want: 2},
+		{name: "lines", text: strings.Repeat(strings.Repeat("x", 30)+"\n", 10), size: 20, want: 5},
+		{name: "multibyte line", text: strings.Repeat("世界", 100), size: 10, want: 16},
+		{name: "emoji line", text: strings.Repeat("😀", 33), size: 3, want: 17},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := splitChunks(tt.text, tt.size)
+			if len(chunks) != tt.want {
+				t.Errorf("got %d chunks, want %d", len(chunks), tt.want)
+			}
+			for i, chunk := range chunks {
+				if !utf8.ValidString(chunk) {
+					t.Errorf("chunk %d %q breaks a rune", i, chunk)
+				}
+				if tokens := provider.EstimateTokens(chunk); tokens > tt.size {
+					t.Errorf("chunk %d has %d tokens, over %d", i, tokens, tt.size)
+				}
+			}
+			if got := strings.Join(chunks, ""); got != tt.text {
+				t.Errorf("chunks don't add up to the text: %q", got)
+			}
+		})
+	}
+}
+
+func TestChunkSizeOfDefaultModel(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+
+	cfg = &config.Config{Provider: config.ProviderGroq}
+	info, err := cfg.LookupModel(provider.DEFAULT_CHAT_MODEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := chunkSize(); got != info.ContextWindow/4 {
+		t.Errorf("chunk size = %d, want quarter of context window of %s", got, provider.DEFAULT_CHAT_MODEL)
+	}
+	if !exceedsContext(strings.Repeat("x", info.ContextWindow*4+8)) {
+		t.Errorf("input over context window of %s isn't chunked", provider.DEFAULT_CHAT_MODEL)
+	}
+
+	cfg = &config.Config{Provider: config.ProviderGroq, ChunkSize: 100}
+	if got := chunkSize(); got != 100 {
+		t.Errorf("chunk size = %d, want configured 100", got)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/daulet/cmd/parser"
+)
+
+const DEFAULT_EDITOR = "vi"
+
+var errAborted = errors.New("aborted by user")
+
+// reviewer asks whether to run each code block, it talks to the terminal
+// directly so it works when input or output is piped.
+type reviewer struct {
+	tty *os.File
+	r   *bufio.Reader
+}
+
+func newReviewer() (*reviewer, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /dev/tty to confirm code execution: %w", err)
+	}
+	return &reviewer{tty: tty, r: bufio.NewReader(tty)}, nil
+}
+
+// review shows the block and what running it does, and returns block to run,
+// possibly edited, or nil when user skips it.
+func (rv *reviewer) review(block *parser.CodeBlock, plan func(*parser.CodeBlock) *blockPlan) (*parser.CodeBlock, error) {
+	for {
+		showBlock(rv.tty, block, plan(block))
+		fmt.Fprint(rv.tty, "Run it? [y]es, [s]kip, [e]dit, [a]bort: ")
+
+		answer, err := rv.r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("failed to read confirmation: %w", err)
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return block, nil
+		case "s", "skip":
+			return nil, nil
+		case "e", "edit":
+			code, err := rv.edit(block)
+			if err != nil {
+				return nil, err
+			}
+			block = &parser.CodeBlock{Lang: block.Lang, Tag: block.Tag, Code: code}
+		case "a", "abort":
+			return nil, errAborted
+		}
+	}
+}
+
+// edit opens the code in $EDITOR and returns edited code.
+func (rv *reviewer) edit(block *parser.CodeBlock) (string, error) {
+	f, err := os.CreateTemp("", "cmd-*."+extension(block))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(block.Code); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	editor := strings.Fields(os.Getenv("VISUAL"))
+	if len(editor) == 0 {
+		editor = strings.Fields(os.Getenv("EDITOR"))
+	}
+	if len(editor) == 0 {
+		editor = []string{DEFAULT_EDITOR}
+	}
+	cmd := exec.Command(editor[0], append(editor[1:], f.Name())...)
+	cmd.Stdin = rv.tty
+	cmd.Stdout = rv.tty
+	cmd.Stderr = rv.tty
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor failed: %w", err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (rv *reviewer) Close() error {
+	return rv.tty.Close()
+}
+
+// extension of files with code of the block, so editors highlight it.
+func extension(block *parser.CodeBlock) string {
+	if runner, err := cfg.LookupRunner(block.Tag); err == nil && filepath.Ext(runner.File) != "" {
+		return strings.TrimPrefix(filepath.Ext(runner.File), ".")
+	}
+	switch block.Lang {
+	case parser.Go:
+		return "go"
+	case parser.Bash:
+		return "sh"
+	case parser.HTML:
+		return "html"
+	case parser.JavaScript:
+		return "js"
+	case parser.CSS:
+		return "css"
+	case parser.Python:
+		return "py"
+	default:
+		return "txt"
+	}
+}
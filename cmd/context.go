@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daulet/cmd/provider"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// DEFAULT_CONTEXT_BUDGET is used when context window of the model is unknown
+	DEFAULT_CONTEXT_BUDGET = 8000
+
+	// how many bytes are sniffed to detect binary files
+	SNIFF_LEN = 512
+)
+
+// contextSummary is what was left out of the packed directory.
+type contextSummary struct {
+	files     int
+	ignored   int
+	binary    int
+	overLimit []string
+}
+
+// packDir packs text files of dir with path headers, skipping files ignored by .gitignore,
+// binary ones and those that don't fit into the token budget.
+func packDir(dir string, budget int) (string, *contextSummary, error) {
+	var (
+		packed  []string
+		summary = &contextSummary{}
+	)
+	ignore, err := newGitignore(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read ignore files: %w", err)
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel == "." {
+				return ignore.load(dir, "")
+			}
+			if d.Name() == ".git" || ignore.ignored(rel, true) {
+				summary.ignored++
+				return filepath.SkipDir
+			}
+			return ignore.load(dir, rel)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if ignore.ignored(rel, false) {
+			summary.ignored++
+			return nil
+		}
+		data, err := readText(path, budget)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			summary.binary++
+			return nil
+		}
+		file := fmt.Sprintf(FILE_TEMPLATE, rel, strings.TrimRight(string(data), "\n"))
+		tokens := provider.EstimateTokens(file)
+		if tokens > budget {
+			// smaller files might still fit
+			summary.overLimit = append(summary.overLimit, rel)
+			return nil
+		}
+		budget -= tokens
+		packed = append(packed, file)
+		summary.files++
+		return nil
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read context directory: %w", err)
+	}
+	if len(summary.overLimit) > 0 {
+		packed = append(packed, fmt.Sprintf("Files left out to fit the context: %s", strings.Join(summary.overLimit, ", ")))
+	}
+	return strings.Join(packed, "\n\n"), summary, nil
+}
+
+// readText reads file unless it's binary, files way over the budget
+// are only read as much as needed to tell they don't fit.
+func readText(path string, budget int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sniff := make([]byte, SNIFF_LEN)
+	n, err := io.ReadFull(f, sniff)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if !strings.HasPrefix(http.DetectContentType(sniff[:n]), TEXT_MIME_PREFIX) {
+		return nil, nil
+	}
+	rest, err := io.ReadAll(io.LimitReader(f, int64(max(budget, 0))*4))
+	if err != nil {
+		return nil, err
+	}
+	return append(sniff[:n], rest...), nil
+}
+
+// contextBudget is how many tokens directory context can take: configured budget,
+// otherwise half of context window of the chat model, leaving room for the answer.
+func contextBudget() int {
+	if cfg.ContextBudget > 0 {
+		return cfg.ContextBudget
+	}
+	if window := contextWindow(); window > 0 {
+		return window / 2
+	}
+	return DEFAULT_CONTEXT_BUDGET
+}
+
+// readContext packs directories passed with --context, reporting what was skipped to stderr.
+func readContext(dirs []string) ([]string, error) {
+	var (
+		contexts []string
+		budget   = contextBudget()
+	)
+	for _, dir := range dirs {
+		packed, summary, err := packDir(dir, budget)
+		if err != nil {
+			return nil, err
+		}
+		used := provider.EstimateTokens(packed)
+		color.New(color.FgHiBlack).Fprintf(os.Stderr,
+			"context %s: %d files included, %d ignored, %d binary, %d over the budget, %d of %d tokens used\n",
+			dir, summary.files, summary.ignored, summary.binary, len(summary.overLimit), used, budget,
+		)
+		budget -= used
+		if packed != "" {
+			contexts = append(contexts, packed)
+		}
+	}
+	return contexts, nil
+}
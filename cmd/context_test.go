@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/daulet/cmd/config"
+	"github.com/daulet/cmd/provider"
+)
+
+func TestContextBudgetOfDefaultModel(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+
+	cfg = &config.Config{Provider: config.ProviderAnthropic}
+	info, err := cfg.LookupModel(provider.DEFAULT_ANTHROPIC_CHAT_MODEL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := contextBudget(); got != info.ContextWindow/2 {
+		t.Errorf("context budget = %d, want half of context window of %s", got, provider.DEFAULT_ANTHROPIC_CHAT_MODEL)
+	}
+
+	cfg = &config.Config{Provider: config.ProviderCohere}
+	if got := contextBudget(); got != DEFAULT_CONTEXT_BUDGET {
+		t.Errorf("context budget = %d, want default %d when model is unknown", got, DEFAULT_CONTEXT_BUDGET)
+	}
+
+	cfg = &config.Config{Provider: config.ProviderAnthropic, ContextBudget: 1000}
+	if got := contextBudget(); got != 1000 {
+		t.Errorf("context budget = %d, want configured 1000", got)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daulet/cmd/config"
+	"github.com/daulet/cmd/provider"
+)
+
+const (
+	TEXT_MIME_PREFIX = "text/"
+
+	FILE_TEMPLATE       = "%s:\n```\n%s\n```"
+	TRANSCRIPT_TEMPLATE = "Transcript of %s:\n%s"
+)
+
+// inputFile is a file passed with --file, read and classified by content.
+type inputFile struct {
+	path        string
+	data        []byte
+	contentType string
+}
+
+// readFiles expands globs and reads all matched files in order, each file once.
+func readFiles(patterns []string) ([]*inputFile, error) {
+	var (
+		files []*inputFile
+		seen  = make(map[string]bool)
+	)
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern %s: %w", pattern, err)
+		}
+		if len(paths) == 0 {
+			// not a glob, so opening it reports what's wrong with the path
+			paths = []string{pattern}
+		}
+		for _, path := range paths {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read file: %w", err)
+			}
+			files = append(files, &inputFile{
+				path:        path,
+				data:        data,
+				contentType: http.DetectContentType(data),
+			})
+		}
+	}
+	return files, nil
+}
+
+// allAudio reports whether there are only audio files, which are just transcribed
+// when there is no prompt.
+func allAudio(files []*inputFile) bool {
+	for _, file := range files {
+		if !strings.HasPrefix(file.contentType, AUDIO_MIME_PREFIX) {
+			return false
+		}
+	}
+	return len(files) > 0
+}
+
+// transcribe returns transcript of audio file with timestamps of each segment.
+func transcribe(ctx context.Context, file *inputFile) (string, error) {
+	segments, err := prov.Transcribe(ctx, cfg, &provider.AudioFile{FilePath: file.path, Reader: bytes.NewReader(file.data)})
+	if err != nil {
+		return "", fmt.Errorf("failed to transcribe %s: %w", file.path, err)
+	}
+	var out strings.Builder
+	for _, segment := range segments {
+		out.WriteString(fmt.Sprintf("%v - %v\n", segment.Start, segment.End))
+		out.WriteString(fmt.Sprintf("%s\n", segment.Text))
+	}
+	return out.String(), nil
+}
+
+// fileMessage builds user message of the prompt, contexts and files: text files are inlined
+// with their names, audio files are transcribed and images are attached.
+// Message is multi part when there are images, and the first part is the prompt.
+func fileMessage(ctx context.Context, prompt string, contexts []string, files []*inputFile) (*provider.Message, error) {
+	var (
+		texts  = contexts
+		images []*provider.MessagePart
+	)
+	for _, file := range files {
+		switch {
+		case strings.HasPrefix(file.contentType, TEXT_MIME_PREFIX):
+			texts = append(texts, fmt.Sprintf(FILE_TEMPLATE, file.path, strings.TrimRight(string(file.data), "\n")))
+		case strings.HasPrefix(file.contentType, AUDIO_MIME_PREFIX):
+			transcript, err := transcribe(ctx, file)
+			if err != nil {
+				return nil, err
+			}
+			texts = append(texts, fmt.Sprintf(TRANSCRIPT_TEMPLATE, file.path, transcript))
+		case strings.HasPrefix(file.contentType, IMAGE_MIME_PREFIX):
+			images = append(images, &provider.MessagePart{
+				Field: &provider.ImagePart{
+					Data: fmt.Sprintf("data:%s;base64,%s", file.contentType, base64.StdEncoding.EncodeToString(file.data)),
+				},
+			})
+		default:
+			return nil, fmt.Errorf("unsupported type %s of file %s", file.contentType, file.path)
+		}
+	}
+
+	message := &provider.Message{Role: provider.User}
+	if len(images) == 0 {
+		message.Content = prompt
+		if len(texts) > 0 {
+			message.Content = fmt.Sprintf(CONTEXT_TEMPLATE, strings.Join(texts, "\n\n"), prompt)
+		}
+		return message, nil
+	}
+
+	if err := checkVision(); err != nil {
+		return nil, err
+	}
+	message.MultiPart = append(message.MultiPart, &provider.MessagePart{
+		Field: &provider.TextPart{
+			Text: prompt,
+		},
+	})
+	if len(texts) > 0 {
+		message.MultiPart = append(message.MultiPart, &provider.MessagePart{
+			Field: &provider.TextPart{
+				Text: strings.Join(texts, "\n\n"),
+			},
+		})
+	}
+	message.MultiPart = append(message.MultiPart, images...)
+	return message, nil
+}
+
+// checkVision fails early when model that receives images can't see them,
+// instead of provider rejecting or ignoring the images.
+func checkVision() error {
+	model := provider.ImageModel(cfg)
+	info, err := cfg.LookupModel(model)
+	if err != nil {
+		// unknown models are left for provider to check
+		return nil
+	}
+	if !info.Has(config.CapabilityVision) {
+		return fmt.Errorf("model %s doesn't support images, use --model to select one with vision", model)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/daulet/cmd/config"
+)
+
+func TestCheckVision(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		model    map[string]string
+		wantErr  bool
+	}{
+		{name: "anthropic default", provider: config.ProviderAnthropic},
+		{name: "anthropic chat model", provider: config.ProviderAnthropic, model: map[string]string{config.ModelTypeChat: "claude-3-5-haiku-latest"}, wantErr: true},
+		{name: "anthropic leaves groq image model", provider: config.ProviderAnthropic, model: map[string]string{config.ModelTypeChat: "claude-3-5-haiku-latest", config.ModelTypeChatImage: "llama-3.2-90b-vision-preview"}, wantErr: true},
+		{name: "groq default image model", provider: config.ProviderGroq},
+		{name: "groq chat model can't see", provider: config.ProviderGroq, model: map[string]string{config.ModelTypeChat: "llama-3.3-70b-versatile"}},
+		{name: "groq image model can't see", provider: config.ProviderGroq, model: map[string]string{config.ModelTypeChatImage: "llama-3.3-70b-versatile"}, wantErr: true},
+		{name: "unknown model", provider: config.ProviderOllama, model: map[string]string{config.ModelTypeChatImage: "my-model"}},
+	}
+	oldCfg := cfg
+	defer func() { cfg = oldCfg }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg = &config.Config{Provider: tt.provider, Model: tt.model}
+			if err := checkVision(); (err != nil) != tt.wantErr {
+				t.Errorf("checkVision = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/daulet/cmd/provider"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// how much of code output is sent back to fix the code
+	OUTPUT_TAIL_LEN = 8192
+	// how much of the last output line is shown in the log of attempts
+	LOG_LINE_LEN = 80
+
+	FIX_TEMPLATE = "Running the %s code failed with %v, its output was:\n```\n%s\n```\nFix the code and reply with all code blocks needed to run it."
+)
+
+// runError is failure of generated code along with its output.
+type runError struct {
+	lang   string
+	err    error
+	output string
+}
+
+func (e *runError) Error() string {
+	return e.err.Error()
+}
+
+func (e *runError) Unwrap() error {
+	return e.err
+}
+
+// fixTurn runs the turn, and while its code fails sends the failure back to the model
+// to fix the code, up to attempts times, then prints what happened on each attempt.
+func fixTurn(
+	ctx context.Context,
+	out io.WriteCloser,
+	msgs []*provider.Message,
+	attempts int,
+	turnFn func(context.Context, io.WriteCloser, []*provider.Message) (string, error),
+) (string, error) {
+	var log []string
+	defer func() {
+		if len(log) > 1 {
+			color.New(color.FgHiBlack).Fprintf(os.Stderr, "fix attempts:\n%s\n", strings.Join(log, "\n"))
+		}
+	}()
+	for attempt := 0; ; attempt++ {
+		response, err := turnFn(ctx, out, msgs)
+		var runErr *runError
+		if err == nil {
+			log = append(log, fmt.Sprintf("  %d: ok", attempt+1))
+			return response, nil
+		}
+		if !errors.As(err, &runErr) || ctx.Err() != nil {
+			return "", err
+		}
+		log = append(log, fmt.Sprintf("  %d: %s failed with %v%s", attempt+1, runErr.lang, runErr.err, lastLine(runErr.output)))
+		if attempt == attempts {
+			return "", err
+		}
+		color.New(color.FgYellow).Fprintf(os.Stderr, "code failed, asking to fix it (%d of %d)\n", attempt+1, attempts)
+		msgs = append(slices.Clip(msgs),
+			&provider.Message{
+				Role:    provider.Assistant,
+				Content: response,
+			},
+			&provider.Message{
+				Role:    provider.User,
+				Content: fmt.Sprintf(FIX_TEMPLATE, runErr.lang, runErr.err, strings.TrimRight(runErr.output, "\n")),
+			},
+		)
+	}
+}
+
+// lastLine of output to tell apart failures in the log, e.g. the exception.
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	line := strings.TrimSpace(lines[len(lines)-1])
+	if line == "" {
+		return ""
+	}
+	if runes := []rune(line); len(runes) > LOG_LINE_LEN {
+		line = string(runes[:LOG_LINE_LEN]) + "..."
+	}
+	return ": " + line
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ignorePattern is a single line of .gitignore.
+type ignorePattern struct {
+	// base is directory of .gitignore relative to the root, "" for the root
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// gitignore matches paths against .gitignore files of the walked tree and of its
+// repository, it covers commonly used syntax: negation, anchoring, directory only patterns and **.
+type gitignore struct {
+	patterns []*ignorePattern
+	// prefix is the walked directory relative to the repository root, patterns
+	// are matched against paths relative to the root
+	prefix string
+}
+
+// newGitignore prepares matching for walking dir. When dir is in a git repository,
+// it loads core.excludesFile, .git/info/exclude and .gitignore files from the
+// repository root down to dir, in the order of increasing precedence.
+func newGitignore(dir string) (*gitignore, error) {
+	g := &gitignore{}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, gitDir := repoRoot(abs)
+	if root == "" {
+		return g, nil
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return nil, err
+	}
+	if rel != "." {
+		g.prefix = filepath.ToSlash(rel)
+	}
+	if err := g.read(excludesFile(root), ""); err != nil {
+		return nil, err
+	}
+	if err := g.read(filepath.Join(gitDir, "info", "exclude"), ""); err != nil {
+		return nil, err
+	}
+	// .gitignore of dir itself is loaded by the walk
+	var parents []string
+	for parent := abs; parent != root; {
+		parent = filepath.Dir(parent)
+		parents = append(parents, parent)
+	}
+	slices.Reverse(parents)
+	for _, parent := range parents {
+		base, err := filepath.Rel(root, parent)
+		if err != nil {
+			return nil, err
+		}
+		if base == "." {
+			base = ""
+		}
+		if err := g.read(filepath.Join(parent, ".gitignore"), filepath.ToSlash(base)); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// repoRoot returns the closest directory of dir containing .git and the git directory,
+// which is .git itself or the one it points to in worktrees and submodules.
+func repoRoot(dir string) (string, string) {
+	for {
+		gitPath := filepath.Join(dir, ".git")
+		if info, err := os.Stat(gitPath); err == nil {
+			if info.IsDir() {
+				return dir, gitPath
+			}
+			data, err := os.ReadFile(gitPath)
+			if gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: "); err == nil && ok {
+				if !filepath.IsAbs(gitDir) {
+					gitDir = filepath.Join(dir, gitDir)
+				}
+				return dir, gitDir
+			}
+			return dir, gitPath
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// excludesFile returns core.excludesFile of the repository at root,
+// defaulting to git/ignore in the XDG config directory.
+func excludesFile(root string) string {
+	cmd := exec.Command("git", "config", "--get", "core.excludesFile")
+	cmd.Dir = root
+	if out, err := cmd.Output(); err == nil {
+		file := strings.TrimSpace(string(out))
+		if rest, ok := strings.CutPrefix(file, "~/"); ok {
+			home, _ := os.UserHomeDir()
+			file = filepath.Join(home, rest)
+		}
+		return file
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "git", "ignore")
+}
+
+// load reads .gitignore of directory dir, slash separated and relative to the walked root.
+func (g *gitignore) load(root, dir string) error {
+	base := dir
+	if g.prefix != "" {
+		base = path.Join(g.prefix, dir)
+	}
+	return g.read(filepath.Join(root, filepath.FromSlash(dir), ".gitignore"), base)
+}
+
+// read adds patterns of ignore file, base is its directory relative to the repository root.
+func (g *gitignore) read(file, base string) error {
+	if file == "" {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := &ignorePattern{base: base}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, "\\")
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// patterns with a slash are relative to .gitignore, others match at any level
+		prefix := "^(?:.*/)?"
+		if strings.Contains(line, "/") {
+			prefix = "^"
+			line = strings.TrimPrefix(line, "/")
+		}
+		re, err := regexp.Compile(prefix + globRegexp(line) + "$")
+		if err != nil {
+			// skip what we can't parse rather than failing on it
+			continue
+		}
+		p.re = re
+		g.patterns = append(g.patterns, p)
+	}
+	return scanner.Err()
+}
+
+// ignored reports whether rel path of the walked root is ignored, the last matching pattern wins.
+func (g *gitignore) ignored(rel string, isDir bool) bool {
+	if g.prefix != "" {
+		rel = path.Join(g.prefix, rel)
+	}
+	ignored := false
+	for _, p := range g.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if p.base != "" {
+			var ok bool
+			sub, ok = strings.CutPrefix(rel, p.base+"/")
+			if !ok {
+				continue
+			}
+		}
+		if p.re.MatchString(sub) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// globRegexp translates gitignore glob to regular expression.
+func globRegexp(glob string) string {
+	var re strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			re.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				re.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
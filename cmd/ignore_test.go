@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGitignore(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{name: "name at any level", pattern: "*.log", path: "a/b/x.log", want: true},
+		{name: "star stops at slash", pattern: "a/*.go", path: "a/b/x.go", want: false},
+		{name: "anchored", pattern: "/build", path: "sub/build", isDir: true, want: false},
+		{name: "anchored root", pattern: "/build", path: "build", isDir: true, want: true},
+		{name: "leading **", pattern: "**/testdata", path: "a/b/testdata", isDir: true, want: true},
+		{name: "leading ** at root", pattern: "**/testdata", path: "testdata", isDir: true, want: true},
+		{name: "trailing **", pattern: "vendor/**", path: "vendor/a/b.go", want: true},
+		{name: "trailing ** not dir itself", pattern: "vendor/**", path: "vendor", isDir: true, want: false},
+		{name: "middle **", pattern: "a/**/z", path: "a/b/c/z", want: true},
+		{name: "middle ** zero dirs", pattern: "a/**/z", path: "a/z", want: true},
+		{name: "dir only matches dir", pattern: "out/", path: "out", isDir: true, want: true},
+		{name: "dir only skips file", pattern: "out/", path: "out", want: false},
+		{name: "negation", pattern: "*.log\n!keep.log", path: "keep.log", want: false},
+		{name: "negation of other file", pattern: "*.log\n!keep.log", path: "drop.log", want: true},
+		{name: "last match wins", pattern: "!keep.log\n*.log", path: "keep.log", want: true},
+		{name: "escaped", pattern: "\\!important", path: "!important", want: true},
+		{name: "class", pattern: "x[0-9].txt", path: "x1.txt", want: true},
+		{name: "negated class", pattern: "x[!0-9].txt", path: "x1.txt", want: false},
+		{name: "comment", pattern: "# x.txt", path: "# x.txt", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, ".gitignore"), tt.pattern)
+			g := &gitignore{}
+			if err := g.load(dir, ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := g.ignored(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("ignored(%q) with %q = %v, want %v", tt.path, tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitignoreRepository(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	writeFile(t, filepath.Join(home, ".config", "git", "ignore"), "*.swp\n")
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ".git", "info", "exclude"), "local/\n")
+	writeFile(t, filepath.Join(root, ".gitignore"), "*.log\n/top.txt\nbuild/\n")
+	writeFile(t, filepath.Join(root, "a", ".gitignore"), "!keep.log\n")
+	writeFile(t, filepath.Join(root, "a", "b", ".gitignore"), "gen/\n")
+
+	g, err := newGitignore(filepath.Join(root, "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.load(filepath.Join(root, "a", "b"), ""); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "x.swp", want: true},
+		{path: "local", isDir: true, want: true},
+		{path: "x.log", want: true},
+		{path: "keep.log", want: false},
+		{path: "top.txt", want: false},
+		{path: "build", isDir: true, want: true},
+		{path: "gen", isDir: true, want: true},
+		{path: "main.go", want: false},
+	}
+	for _, tt := range tests {
+		if got := g.ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPackDirOutsideRepository(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ".gitignore"), "*.log\n")
+	writeFile(t, filepath.Join(dir, "x.log"), "log")
+	writeFile(t, filepath.Join(dir, "main.go"), "package main")
+	_, summary, err := packDir(dir, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// .gitignore itself is packed too
+	if summary.files != 2 || summary.ignored != 1 {
+		t.Errorf("files = %d, ignored = %d, want 2 and 1", summary.files, summary.ignored)
+	}
+}
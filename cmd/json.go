@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/daulet/cmd/parser"
+	"github.com/daulet/cmd/provider"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// JSON_RETRIES is how many times model is asked to fix invalid JSON
+	JSON_RETRIES = 2
+
+	JSON_INSTRUCTION        = "Respond only with valid JSON, without any prose or code fences."
+	JSON_SCHEMA_INSTRUCTION = "%s The JSON must match this JSON schema:\n%s"
+	JSON_FIX_TEMPLATE       = "Your response is not valid: %v. Respond with corrected JSON only."
+)
+
+func readJSONSchema(path string) (json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON schema: %w", err)
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("JSON schema %s is not valid JSON", path)
+	}
+	return data, nil
+}
+
+// generateJSON requests JSON output and validates it against the schema (if set),
+// feeding validation errors back to the model. Only valid JSON is written to out.
+func generateJSON(
+	ctx context.Context,
+	out io.WriteCloser,
+	msgs []*provider.Message,
+	schema json.RawMessage,
+) (string, error) {
+	instruction := JSON_INSTRUCTION
+	if schema != nil {
+		instruction = fmt.Sprintf(JSON_SCHEMA_INSTRUCTION, JSON_INSTRUCTION, schema)
+	}
+	msgs = append([]*provider.Message{
+		{
+			Role:    provider.System,
+			Content: instruction,
+		},
+	}, msgs...)
+
+	for attempt := 0; ; attempt++ {
+		response, err := generate(ctx, parser.MultiWriter(io.Discard), msgs, provider.WithJSON(schema))
+		if err != nil {
+			return "", err
+		}
+		text := parser.ExtractJSON(response)
+		err = validateJSON(schema, text)
+		if err == nil {
+			out.Write([]byte(text))
+			out.Write([]byte("\n"))
+			return text, nil
+		}
+		if attempt >= JSON_RETRIES {
+			return "", fmt.Errorf("no valid JSON after %d attempts: %w", attempt+1, err)
+		}
+		color.New(color.FgYellow).Fprintf(os.Stderr, "invalid JSON, retrying: %v\n", err)
+		msgs = append(msgs,
+			&provider.Message{
+				Role:    provider.Assistant,
+				Content: response,
+			},
+			&provider.Message{
+				Role:    provider.User,
+				Content: fmt.Sprintf(JSON_FIX_TEMPLATE, err),
+			},
+		)
+	}
+}
+
+func validateJSON(schema json.RawMessage, text string) error {
+	if schema != nil {
+		return parser.ValidateJSON(schema, []byte(text))
+	}
+	var v any
+	return json.Unmarshal([]byte(text), &v)
+}
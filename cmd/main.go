@@ -4,15 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/daulet/cmd/config"
 	"github.com/daulet/cmd/parser"
@@ -32,28 +33,50 @@ const (
 var (
 	prov provider.Provider
 	cfg  *config.Config
+	// printStats prints usage of each request to stderr
+	printStats bool
 )
 
 type flagValues struct {
-	Interactive bool `short:"i" long:"interactive" description:"Start chat session with LLM, other flags apply."`
-	Execute     bool `short:"e" long:"execute" description:"Execute generated command/code, do not show LLM output."`
-	Run         bool `short:"r" long:"run" description:"Stream LLM output and run generated command/code at the end."`
-	// TODO support multiple files to allow multiple images
-	File *string `short:"f" long:"file" description:"File to process, depending on the type it will either be transcribed or sent as image."`
+	Interactive bool     `short:"i" long:"interactive" description:"Start chat session with LLM, other flags apply."`
+	Execute     bool     `short:"e" long:"execute" description:"Execute generated command/code, do not show LLM output."`
+	Run         bool     `short:"r" long:"run" description:"Stream LLM output and run generated command/code at the end."`
+	Offline     bool     `long:"offline" description:"Only replay cached responses, fail on cache miss."`
+	Refresh     bool     `long:"refresh" description:"Don't replay cached responses, record new ones in their place."`
+	NoCache     bool     `long:"no-cache" description:"Neither replay nor record responses."`
+	Tools       bool     `long:"tools" description:"Let LLM read files, list directories and query git in current directory."`
+	Stats       bool     `long:"stats" description:"Print token usage, time to first token and cost to stderr."`
+	Confirm     bool     `long:"confirm" description:"Ask before running each generated code block, with option to edit it."`
+	DryRun      bool     `long:"dry-run" description:"Show generated code blocks and what running them would do, without running them."`
+	Sandbox     bool     `long:"sandbox" description:"Run generated code in sandbox: read only file system, no network, limited resources (Linux only)."`
+	Keep        bool     `long:"keep" description:"Keep directory generated code is written to, it's removed after successful run otherwise."`
+	WorkDir     *string  `long:"workdir" description:"Directory to write and run generated code in, instead of a new temporary one. It's kept."`
+	Fix         int      `long:"fix" description:"Send output of failed code back to LLM to fix it, up to this many times. Applies to --run."`
+	Chunk       bool     `long:"chunk" description:"Process piped input in chunks and merge answers, for input larger than model context."`
+	Context     []string `long:"context" description:"Directory to include text files of, respecting .gitignore. Can be repeated."`
+	File        []string `short:"f" long:"file" description:"File to include, text is inlined, audio is transcribed and images are attached. Can be repeated and accepts globs."`
 
 	ShowConfig bool `short:"c" long:"config" description:"Show current config."`
+	ShowUsage  bool `long:"usage" description:"Show token usage and cost by day and model."`
 
 	ListModels bool    `long:"list-models" description:"List available models."`
+	JSON       bool    `long:"json" description:"Output in JSON format, applies to generation and --list-models."`
+	JSONSchema *string `long:"json-schema" description:"JSON schema file to validate generated JSON against, implies --json."`
 	SetModel   *string `long:"model" description:"Set model to use."`
 
 	ListConnectors bool     `long:"list-connectors" description:"List available connectors."`
 	SetConnectors  []string `long:"connector" description:"Set connectors to use."`
 
+	SetSystemPrompt *string `long:"system" description:"Set system prompt, empty value unsets it."`
+
 	SetTemperature      *float64 `short:"t" long:"temperature" description:"Set temperature value."`
 	SetTopP             *float64 `short:"p" long:"top-p" description:"Set top-p value."`
 	SetTopK             *int     `short:"k" long:"top-k" description:"Set top-k value."`
 	SetFrequencyPenalty *float64 `long:"freq" description:"Set frequency penalty value."`
 	SetPresencePenalty  *float64 `long:"pres" description:"Set presence penalty value."`
+	SetMaxTokens        *int     `long:"max-tokens" description:"Set max tokens to generate."`
+	SetStop             []string `long:"stop" description:"Set stop sequences."`
+	SetSeed             *int     `long:"seed" description:"Set seed for reproducible sampling."`
 }
 
 func multiTurn(
@@ -64,36 +87,62 @@ func multiTurn(
 	turnFn func(context.Context, io.WriteCloser, []*provider.Message) (string, error),
 ) error {
 	var (
-		r    = bufio.NewScanner(in)
-		msgs []*provider.Message
+		next    = make(chan struct{})
+		lines   = make(chan string)
+		scanErr = make(chan error, 1)
+		msgs    []*provider.Message
 	)
+	defer close(next)
+	// input is read in background so Ctrl-C isn't blocked on it, and only when
+	// asked for, so it doesn't compete with confirmation prompts for the terminal
+	go func() {
+		r := bufio.NewScanner(in)
+		for range next {
+			if !r.Scan() {
+				scanErr <- r.Err()
+				return
+			}
+			lines <- r.Text()
+		}
+	}()
+	// Ctrl-C aborts current generation, or ends the session when waiting for input
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
 	for {
+		out.Write([]byte("User> ")) // TODO does this polute the output?
+		next <- struct{}{}
+		var userMsg string
 		select {
 		case <-ctx.Done():
 			return nil
-		default:
-		}
-
-		out.Write([]byte("User> ")) // TODO does this polute the output?
-		if !r.Scan() {
-			return r.Err()
+		case <-interrupts:
+			out.Write([]byte("\n"))
+			return nil
+		case err := <-scanErr:
+			return err
+		case userMsg = <-lines:
 		}
-		userMsg := r.Text()
 
 		var nextMsg *provider.Message
 		if contextMsg != nil {
-			if contextMsg.Content != "" {
-				contextMsg.Content = fmt.Sprintf("%s %s", contextMsg.Content, userMsg)
-			} else {
+			// context is copied, so it's still there if generation is aborted
+			msg := *contextMsg
+			switch {
+			case len(msg.MultiPart) > 0:
 				// when first message is multi part, the first part is text
-				contextMsg.MultiPart[0] = &provider.MessagePart{
+				msg.MultiPart = append([]*provider.MessagePart{{
 					Field: &provider.TextPart{
 						Text: userMsg,
 					},
-				}
+				}}, msg.MultiPart[1:]...)
+			case msg.Content != "":
+				msg.Content = fmt.Sprintf("%s %s", msg.Content, userMsg)
+			default:
+				msg.Content = userMsg
 			}
-			nextMsg = contextMsg
-			contextMsg = nil
+			nextMsg = &msg
 		} else {
 			nextMsg = &provider.Message{
 				Role:    provider.User,
@@ -102,10 +151,27 @@ func multiTurn(
 		}
 
 		msgs = append(msgs, nextMsg)
-		botMsg, err := turnFn(ctx, out, msgs)
+		turnCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-interrupts:
+				cancel()
+			case <-turnCtx.Done():
+			}
+		}()
+		botMsg, err := turnFn(turnCtx, out, msgs)
+		aborted := turnCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		if aborted {
+			color.New(color.FgHiBlack).Fprintln(os.Stderr, "\ngeneration aborted")
+			// forget the message, so it can be asked again
+			msgs = msgs[:len(msgs)-1]
+			continue
+		}
 		if err != nil {
 			return err
 		}
+		contextMsg = nil
 
 		msgs = append(msgs,
 			&provider.Message{
@@ -120,80 +186,33 @@ func generate(
 	ctx context.Context,
 	out io.WriteCloser,
 	msgs []*provider.Message,
+	opts ...provider.StreamOption,
 ) (string, error) {
-	reader, err := prov.Stream(ctx, cfg, msgs)
+	if cfg.SystemPrompt != "" {
+		msgs = append([]*provider.Message{
+			{
+				Role:    provider.System,
+				Content: cfg.SystemPrompt,
+			},
+		}, msgs...)
+	}
+	reader, err := prov.Stream(ctx, cfg, msgs, opts...)
 	if err != nil {
 		return "", err
 	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
 	buf := bytes.NewBuffer(nil)
 	_, err = io.Copy(parser.MultiWriter(out, buf), reader)
 	if err != nil {
 		return "", err
 	}
 	out.Write([]byte("\n"))
-	return buf.String(), nil
-}
-
-// TODO return correct exit code when -run or -exec fails - is this fixed?
-func runBlock(block *parser.CodeBlock, tempDir string) error {
-	switch block.Lang {
-	case parser.Go:
-		code := block.Code
-		// TODO remove when prompt engineering is there to add "make it runnable"
-		if !strings.HasPrefix(block.Code, "package") {
-			code = fmt.Sprintf("package main\n\n%s", block.Code)
-		}
-		path := fmt.Sprintf("%smain.go", tempDir)
-		if err := os.WriteFile(path, []byte(code), 0644); err != nil {
-			return err
-		}
-		if err := runCmd("go", "run", path); err != nil {
-			return err
-		}
-	case parser.Bash:
-		if err := runCmd("bash", "-c", block.Code); err != nil {
-			return err
-		}
-	case parser.HTML:
-		path := fmt.Sprintf("%sindex.html", tempDir)
-		if err := os.WriteFile(path, []byte(block.Code), 0644); err != nil {
-			return err
-		}
-		if err := runCmd("open", fmt.Sprintf("file://%s", path)); err != nil {
-			return err
-		}
-	case parser.JavaScript:
-		path := fmt.Sprintf("%sscript.js", tempDir) // TODO can this be parsed from model output? sometimes changes
-		if err := os.WriteFile(path, []byte(block.Code), 0644); err != nil {
-			return err
-		}
-		// assumed to be part of html, so not executed separately
-	case parser.CSS:
-		path := fmt.Sprintf("%sstyle.css", tempDir) // TODO can this be parsed from model output? sometimes changes
-		if err := os.WriteFile(path, []byte(block.Code), 0644); err != nil {
-			return err
-		}
-		// assumed to be part of html, so not executed separately
-	case parser.Python:
-		path := fmt.Sprintf("%smain.py", tempDir)
-		if err := os.WriteFile(path, []byte(block.Code), 0644); err != nil {
-			return err
-		}
-		if err := runCmd("python", path); err != nil {
-			return err
-		}
+	if reporter, ok := reader.(provider.UsageReporter); ok {
+		reportUsage(reporter.Usage())
 	}
-	return nil
-}
-
-func runCmd(prog string, args ...string) error {
-	cmd := exec.Command(prog, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = &colorWriter{
-		Writer: os.Stderr,
-		Color:  color.New(color.FgHiRed),
-	}
-	return cmd.Run()
+	return buf.String(), nil
 }
 
 func parseConfig(ctx context.Context, flagDefs []*flags.Option, flagVals *flagValues) (bool, error) {
@@ -213,18 +232,16 @@ func parseConfig(ctx context.Context, flagDefs []*flags.Option, flagVals *flagVa
 		return true, nil
 	}
 
-	if flagVals.ListModels {
-		modelNames, err := prov.ListModels(ctx)
-		if err != nil {
+	if flagVals.ShowUsage {
+		if err := showUsage(); err != nil {
 			return false, err
 		}
-		fmt.Println("Available models:")
-		for _, model := range modelNames {
-			fmt.Println(model)
-		}
-		fmt.Println()
-		for modelType, model := range cfg.Model {
-			fmt.Printf("Currently selected model for %s: %s\n", modelType, model)
+		return true, nil
+	}
+
+	if flagVals.ListModels {
+		if err := listModels(ctx, flagVals.JSON); err != nil {
+			return false, err
 		}
 		return true, nil
 	}
@@ -248,12 +265,15 @@ func parseConfig(ctx context.Context, flagDefs []*flags.Option, flagVals *flagVa
 	if flagVals.SetModel != nil {
 		dirtyCfg = true
 		model := *flagVals.SetModel
-		modelType, err := config.ModelType(model)
+		info, err := cfg.LookupModel(model)
 		if err != nil {
 			return false, err
 		}
+		if info.Provider != "" && info.Provider != cfg.Provider {
+			color.Yellow("warning: %s is known as %s model, current provider is %s\n", model, info.Provider, cfg.Provider)
+		}
 		// TODO there is no way to unset model
-		cfg.Model[modelType] = model
+		cfg.Model[info.ModelType()] = model
 	}
 
 	if flagVals.SetConnectors != nil {
@@ -261,6 +281,11 @@ func parseConfig(ctx context.Context, flagDefs []*flags.Option, flagVals *flagVa
 		cfg.Connectors = flagVals.SetConnectors
 	}
 
+	if flagVals.SetSystemPrompt != nil {
+		dirtyCfg = true
+		cfg.SystemPrompt = *flagVals.SetSystemPrompt
+	}
+
 	if flagVals.SetTemperature != nil {
 		dirtyCfg = true
 		cfg.Temperature = flagVals.SetTemperature
@@ -286,6 +311,21 @@ func parseConfig(ctx context.Context, flagDefs []*flags.Option, flagVals *flagVa
 		cfg.PresencePenalty = flagVals.SetPresencePenalty
 	}
 
+	if flagVals.SetMaxTokens != nil {
+		dirtyCfg = true
+		cfg.MaxTokens = flagVals.SetMaxTokens
+	}
+
+	if flagVals.SetStop != nil {
+		dirtyCfg = true
+		cfg.Stop = flagVals.SetStop
+	}
+
+	if flagVals.SetSeed != nil {
+		dirtyCfg = true
+		cfg.Seed = flagVals.SetSeed
+	}
+
 	if dirtyCfg {
 		if err := config.WriteConfig(cfg); err != nil {
 			return false, err
@@ -301,20 +341,29 @@ func parseConfig(ctx context.Context, flagDefs []*flags.Option, flagVals *flagVa
 	return false, nil
 }
 
-func cmd(ctx context.Context, usrMsg string, flagVals *flagValues) error {
+// codeTurn returns turn function that generates response, as JSON if requested,
+// and runs or executes its code blocks with codeExec according to flags.
+func codeTurn(flagVals *flagValues, codeExec *executor, schema json.RawMessage) func(context.Context, io.WriteCloser, []*provider.Message) (string, error) {
 	turnFn := func(ctx context.Context, out io.WriteCloser, msgs []*provider.Message) (string, error) {
 		var blocks []*parser.CodeBlock
 		done := make(chan struct{})
 
+		var execErr error
+		// files are kept only when the latest code fails, e.g. not when it's fixed,
+		// reset before anything runs since --execute runs blocks during generation
+		codeExec.failed = false
 		switch {
 		case flagVals.Execute:
 			codeW, blockCh := parser.NewCode()
 			go func() {
 				defer close(done)
-				tempDir := os.TempDir()
 				for block := range blockCh {
-					// TODO the error is swallowed
-					_ = runBlock(block, tempDir)
+					if execErr != nil {
+						// drain, so generation is not blocked
+						continue
+					}
+					// failure stops the rest of the code, like with --run
+					execErr = codeExec.run(ctx, block)
 				}
 			}()
 			// no output to the user, we just execute the code
@@ -333,9 +382,14 @@ func cmd(ctx context.Context, usrMsg string, flagVals *flagValues) error {
 			close(done)
 		}
 
-		response, err := generate(ctx, out, msgs)
-		if err != nil {
-			return "", err
+		var (
+			response string
+			err      error
+		)
+		if flagVals.JSON {
+			response, err = generateJSON(ctx, out, msgs, schema)
+		} else {
+			response, err = generate(ctx, out, msgs)
 		}
 
 		if out != os.Stdout {
@@ -343,15 +397,69 @@ func cmd(ctx context.Context, usrMsg string, flagVals *flagValues) error {
 			out.Close()
 		}
 		<-done
-		tempDir := os.TempDir()
+		if err != nil {
+			return "", err
+		}
+		if execErr != nil {
+			return "", execErr
+		}
 		for _, block := range blocks {
-			if err := runBlock(block, tempDir); err != nil {
-				return "", err
+			if err := codeExec.run(ctx, block); err != nil {
+				// response is returned along with failure of its code, so it can be fixed
+				return response, err
 			}
 		}
 
 		return response, nil
 	}
+	if flagVals.Fix > 0 {
+		runTurn := turnFn
+		turnFn = func(ctx context.Context, out io.WriteCloser, msgs []*provider.Message) (string, error) {
+			return fixTurn(ctx, out, msgs, flagVals.Fix, runTurn)
+		}
+	}
+	return turnFn
+}
+
+func cmd(ctx context.Context, usrMsg string, flagVals *flagValues) error {
+	var schema json.RawMessage
+	if flagVals.JSONSchema != nil {
+		var err error
+		schema, err = readJSONSchema(*flagVals.JSONSchema)
+		if err != nil {
+			return err
+		}
+		flagVals.JSON = true
+	}
+
+	if flagVals.Fix > 0 && !flagVals.Run {
+		return fmt.Errorf("--fix only applies to --run")
+	}
+	codeExec := &executor{
+		dryRun:  flagVals.DryRun,
+		sandbox: flagVals.Sandbox,
+		keep:    flagVals.Keep,
+		capture: flagVals.Fix > 0,
+	}
+	if flagVals.WorkDir != nil {
+		dir, err := filepath.Abs(*flagVals.WorkDir)
+		if err != nil {
+			return err
+		}
+		codeExec.workDir = dir
+		codeExec.keep = true
+	}
+	defer codeExec.Close()
+	if (flagVals.Run || flagVals.Execute) && !flagVals.DryRun && (flagVals.Confirm || cfg.Confirm) {
+		rv, err := newReviewer()
+		if err != nil {
+			return err
+		}
+		defer rv.Close()
+		codeExec.reviewer = rv
+	}
+
+	turnFn := codeTurn(flagVals, codeExec, schema)
 
 	var (
 		in          io.Reader = os.Stdin
@@ -372,58 +480,49 @@ func cmd(ctx context.Context, usrMsg string, flagVals *flagValues) error {
 			return fmt.Errorf("failed to open /dev/tty: %w", err)
 		}
 	}
-	if usrMsg == "" && pipeContent == "" && !flagVals.Interactive && flagVals.File == nil {
+	if usrMsg == "" && pipeContent == "" && !flagVals.Interactive && len(flagVals.File) == 0 && len(flagVals.Context) == 0 {
 		return fmt.Errorf("what's your command?")
 	}
-	if pipeContent != "" {
-		usrMsg = fmt.Sprintf(CONTEXT_TEMPLATE, pipeContent, usrMsg)
-	}
 
-	message := &provider.Message{
-		Role:    provider.User,
-		Content: usrMsg,
+	files, err := readFiles(flagVals.File)
+	if err != nil {
+		return err
 	}
-
-	if flagVals.File != nil {
-		f, err := os.Open(*flagVals.File)
-		if err != nil {
-			return fmt.Errorf("failed to open file: %w", err)
-		}
-		data, err := io.ReadAll(f)
-		if err != nil {
-			return fmt.Errorf("failed to read file: %w", err)
-		}
-		contentType := http.DetectContentType(data)
-
-		if strings.HasPrefix(contentType, AUDIO_MIME_PREFIX) {
-			segments, err := prov.Transcribe(ctx, cfg, &provider.AudioFile{FilePath: *flagVals.File, Reader: bytes.NewReader(data)})
+	if usrMsg == "" && pipeContent == "" && !flagVals.Interactive && allAudio(files) {
+		// nothing to ask, so just print transcripts, e.g. to pipe them into cmd -i
+		for _, file := range files {
+			transcript, err := transcribe(ctx, file)
 			if err != nil {
-				return fmt.Errorf("failed to transcribe: %w", err)
-			}
-			var out strings.Builder
-			for _, segment := range segments {
-				out.WriteString(fmt.Sprintf("%v - %v\n", segment.Start, segment.End))
-				out.WriteString(fmt.Sprintf("%s\n", segment.Text))
+				return err
 			}
-			os.Stdout.Write([]byte(out.String()))
-			return nil
+			os.Stdout.Write([]byte(transcript))
 		}
-
-		if strings.HasPrefix(contentType, IMAGE_MIME_PREFIX) {
-			message.Content = ""
-			message.MultiPart = append(message.MultiPart,
-				&provider.MessagePart{
-					Field: &provider.TextPart{
-						Text: usrMsg,
-					},
-				},
-				&provider.MessagePart{
-					Field: &provider.ImagePart{
-						Data: fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(data)),
-					},
-				},
-			)
+		return nil
+	}
+	if flagVals.Chunk && flagVals.Interactive {
+		return fmt.Errorf("--chunk is not supported in interactive mode")
+	}
+	contexts, err := readContext(flagVals.Context)
+	if err != nil {
+		return err
+	}
+	if pipeContent != "" && !flagVals.Interactive && (flagVals.Chunk || exceedsContext(pipeContent)) {
+		if !flagVals.Chunk {
+			color.New(color.FgHiBlack).Fprintln(os.Stderr, "input exceeds model context, processing it in chunks")
+		}
+		// answers of chunks are merged by the request below
+		usrMsg, err = mapReduce(ctx, pipeContent, usrMsg)
+		if err != nil {
+			return err
 		}
+		pipeContent = ""
+	}
+	if pipeContent != "" {
+		contexts = append([]string{pipeContent}, contexts...)
+	}
+	message, err := fileMessage(ctx, usrMsg, contexts, files)
+	if err != nil {
+		return err
 	}
 
 	switch {
@@ -435,6 +534,82 @@ func cmd(ctx context.Context, usrMsg string, flagVals *flagValues) error {
 	return err
 }
 
+func newProvider(cfg *config.Config) (provider.Provider, error) {
+	switch cfg.Provider {
+	case config.ProviderGroq:
+		return provider.NewGroqProvider()
+	case config.ProviderCohere:
+		return provider.NewCohereProvider()
+	case config.ProviderOpenAICompatible:
+		return provider.NewOpenAICompatibleProvider(cfg.OpenAICompatible)
+	case config.ProviderAnthropic:
+		return provider.NewAnthropicProvider()
+	case config.ProviderOllama:
+		return provider.NewOllamaProvider()
+	case config.ProviderFake:
+		script, err := provider.ReadFakeScript(cfg.FakeScript)
+		if err != nil {
+			return nil, err
+		}
+		return provider.NewFakeProvider(script)
+	default:
+		return nil, fmt.Errorf("unknown provider in config: %s", cfg.Provider)
+	}
+}
+
+// newFallbackProvider chains configured provider with fallback ones, only
+// the last one is retried, the rest fall back to the next one right away.
+func newFallbackProvider(cfg *config.Config) (provider.Provider, error) {
+	// failed provider is not retried when there is another one to try
+	p, err := newLimitedProvider(cfg, len(cfg.Fallback) > 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Fallback) == 0 {
+		return p, nil
+	}
+	chain := []*provider.Fallback{{Name: cfg.Provider, Provider: p}}
+	for i, fb := range cfg.Fallback {
+		fbCfg := *cfg
+		fbCfg.Provider = fb.Provider
+		p, err := newLimitedProvider(&fbCfg, i < len(cfg.Fallback)-1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create fallback provider: %w", err)
+		}
+		chain = append(chain, &provider.Fallback{
+			Name:     fb.Provider,
+			Provider: p,
+			Model:    fb.Model,
+		})
+	}
+	return provider.NewFallbackProvider(chain)
+}
+
+// newLimitedProvider creates configured provider that waits for client side
+// rate limits and retries failed requests. When it falls back, it does neither,
+// so the next provider of the chain is tried right away.
+func newLimitedProvider(cfg *config.Config, fallsBack bool) (provider.Provider, error) {
+	p, err := newProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.RateLimits) > 0 {
+		statePath, err := config.RateLimitStatePath()
+		if err != nil {
+			return nil, err
+		}
+		newRateLimit := provider.NewRateLimitProvider
+		if fallsBack {
+			newRateLimit = provider.NewFailFastRateLimitProvider
+		}
+		p = newRateLimit(p, cfg.Provider, cfg.RateLimits, statePath)
+	}
+	if fallsBack {
+		return p, nil
+	}
+	return provider.NewRetryProvider(p, cfg.Retry), nil
+}
+
 func run() error {
 	flagVals := &flagValues{}
 	parser := flags.NewParser(nil, flags.Default)
@@ -455,19 +630,35 @@ func run() error {
 		return err
 	}
 
-	switch cfg.Provider {
-	case config.ProviderGroq:
-		prov, err = provider.NewGroqProvider()
-	case config.ProviderCohere:
-		prov, err = provider.NewCohereProvider()
-	default:
-		return fmt.Errorf("unknown provider in config: %s", cfg.Provider)
+	if flagVals.Offline {
+		cfg.Replay = true
 	}
+	printStats = flagVals.Stats
+	cachePath, err := cfg.CacheFile()
 	if err != nil {
 		return err
 	}
+	if cfg.Replay {
+		replay, err := provider.NewReplayProvider(cachePath)
+		if err != nil {
+			return fmt.Errorf("failed to create replay provider: %w", err)
+		}
+		defer replay.Close()
+		prov = replay
+	} else {
+		prov, err = newFallbackProvider(cfg)
+		if err != nil {
+			return err
+		}
+	}
 
-	ctx := context.Background()
+	// interactive mode handles Ctrl-C itself to abort only current generation
+	signals := []os.Signal{syscall.SIGTERM}
+	if !flagVals.Interactive {
+		signals = append(signals, os.Interrupt)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), signals...)
+	defer stop()
 	done, err := parseConfig(ctx, flagDefs, flagVals)
 	if err != nil {
 		return err
@@ -476,26 +667,62 @@ func run() error {
 		return nil
 	}
 
-	if cfg.Record {
-		closer, err := provider.NewCacheProvider(prov, ".cache/cache.json")
+	if cfg.Record && !cfg.Replay && !flagVals.NoCache {
+		newCache := provider.NewCacheProvider
+		if flagVals.Refresh {
+			newCache = provider.NewRefreshProvider
+		}
+		closer, err := newCache(prov, cachePath, cfg.CacheChats)
 		if err != nil {
-			log.Fatalf("failed to create cache provider: %v", err)
+			return fmt.Errorf("failed to create cache provider: %w", err)
 		}
-		defer closer.Close()
+		defer func() {
+			if err := closer.Close(); err != nil {
+				color.New(color.FgYellow).Fprintf(os.Stderr, "warning: failed to write cache: %v\n", err)
+			}
+		}()
 		prov = closer
 	}
 
-	return cmd(ctx, usrMsg, flagVals)
+	if flagVals.Tools {
+		prov = provider.NewToolProvider(prov, builtinTools())
+	}
+
+	if err := cmd(ctx, usrMsg, flagVals); err != nil {
+		if ctx.Err() != nil {
+			// whatever failed was interrupted
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == SANDBOX_INIT_ARG {
+		// cmd is re-executed as init process of the sandbox
+		if err := sandboxInit(); err != nil {
+			fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+			os.Exit(SANDBOX_SETUP_EXIT_CODE)
+		}
+		return
+	}
 	err := run()
 	if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
 		os.Exit(1)
 	}
-	if exitErr, ok := err.(*exec.ExitError); ok {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if err.Error() != exitErr.Error() {
+			// failure is explained, e.g. by sandbox
+			color.Yellow("error: %v\n", err)
+		}
 		os.Exit(exitErr.ExitCode())
 	}
+	if errors.Is(err, context.Canceled) {
+		// interrupted, conventional exit code of SIGINT
+		os.Exit(130)
+	}
 	if err != nil {
 		color.Yellow("error: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daulet/cmd/config"
+	"github.com/daulet/cmd/parser"
+	"github.com/daulet/cmd/provider"
+)
+
+// useFake makes fake provider with the turns the provider of cmd for the test.
+func useFake(t *testing.T, turns ...*provider.FakeTurn) provider.Provider {
+	t.Helper()
+	// usage is recorded in home directory
+	t.Setenv("HOME", t.TempDir())
+	fake, err := provider.NewFakeProvider(&provider.FakeScript{Turns: turns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCfg, oldProv := cfg, prov
+	t.Cleanup(func() {
+		cfg, prov = oldCfg, oldProv
+	})
+	cfg = &config.Config{Provider: config.ProviderFake}
+	prov = fake
+	return fake
+}
+
+// recordingProvider remembers messages of each stream request.
+type recordingProvider struct {
+	provider.Provider
+	requests [][]*provider.Message
+}
+
+func (p *recordingProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*provider.Message, opts ...provider.StreamOption) (io.Reader, error) {
+	p.requests = append(p.requests, msgs)
+	return p.Provider.Stream(ctx, cfg, msgs, opts...)
+}
+
+func TestMultiTurnTools(t *testing.T) {
+	content, err := os.ReadFile("color.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := useFake(t,
+		&provider.FakeTurn{
+			Expect:    []*provider.FakeMessage{{Role: provider.User, Content: "what's in color.go?"}},
+			ToolCalls: []*provider.ToolCall{{ID: "call_1", Name: "read_file", Arguments: `{"path": "color.go"}`}},
+		},
+		&provider.FakeTurn{
+			Expect:   []*provider.FakeMessage{{Role: provider.Tool, Content: string(content)}},
+			Response: "It's a color writer.",
+		},
+		&provider.FakeTurn{
+			Expect: []*provider.FakeMessage{
+				{Role: provider.User, Content: "what's in color.go?"},
+				{Role: provider.Assistant, Content: "It's a color writer."},
+				{Role: provider.User, Content: "and ../secret?"},
+			},
+			ToolCalls: []*provider.ToolCall{{ID: "call_2", Name: "read_file", Arguments: `{"path": "../secret"}`}},
+		},
+		&provider.FakeTurn{
+			Expect:   []*provider.FakeMessage{{Role: provider.Tool, Content: "error: ../secret is outside of the current directory"}},
+			Response: "I can't read it.",
+		},
+	)
+	prov = provider.NewToolProvider(fake, builtinTools())
+
+	var out bytes.Buffer
+	in := strings.NewReader("what's in color.go?\nand ../secret?\n")
+	turnFn := func(ctx context.Context, out io.WriteCloser, msgs []*provider.Message) (string, error) {
+		return generate(ctx, out, msgs)
+	}
+	if err := multiTurn(context.Background(), parser.MultiWriter(&out), in, nil, turnFn); err != nil {
+		t.Fatal(err)
+	}
+	want := "User> It's a color writer.\nUser> I can't read it.\nUser> "
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestFixTurn(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "fixed")
+	fix := func(output string) string {
+		return fmt.Sprintf(FIX_TEMPLATE, "bash", "exit status 3", output)
+	}
+	tests := []struct {
+		name     string
+		attempts int
+		turns    []*provider.FakeTurn
+		fixed    bool
+	}{
+		{
+			name:     "fixed",
+			attempts: 2,
+			turns: []*provider.FakeTurn{
+				{Response: "```bash\necho first; exit 3\n```"},
+				{
+					Expect:   []*provider.FakeMessage{{Role: provider.User, Content: fix("first")}},
+					Response: "```bash\necho second; exit 3\n```",
+				},
+				{
+					Expect:   []*provider.FakeMessage{{Role: provider.User, Content: fix("second")}},
+					Response: fmt.Sprintf("```bash\necho fixed > %s\n```", marker),
+				},
+			},
+			fixed: true,
+		},
+		{
+			name:     "out of attempts",
+			attempts: 1,
+			turns: []*provider.FakeTurn{
+				{Response: "```bash\necho first; exit 3\n```"},
+				{
+					Expect:   []*provider.FakeMessage{{Role: provider.User, Content: fix("first")}},
+					Response: "```bash\necho second; exit 3\n```",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(marker)
+			useFake(t, tt.turns...)
+			codeExec := &executor{workDir: t.TempDir(), keep: true, capture: true}
+			defer codeExec.Close()
+			turnFn := codeTurn(&flagValues{Run: true, Fix: tt.attempts}, codeExec, nil)
+
+			var out bytes.Buffer
+			msgs := []*provider.Message{{Role: provider.User, Content: "print something"}}
+			_, err := turnFn(context.Background(), parser.MultiWriter(&out), msgs)
+			_, statErr := os.Stat(marker)
+			if tt.fixed {
+				if err != nil {
+					t.Fatalf("turn failed: %v", err)
+				}
+				if statErr != nil {
+					t.Errorf("fixed code didn't run: %v", statErr)
+				}
+				if codeExec.failed {
+					t.Errorf("executor reports failure after the code was fixed")
+				}
+				return
+			}
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+				t.Errorf("turn error = %v, want exit status 3", err)
+			}
+			if !codeExec.failed {
+				t.Errorf("executor doesn't report failure")
+			}
+		})
+	}
+}
+
+func TestGenerateJSONRetry(t *testing.T) {
+	schema := json.RawMessage(`{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}`)
+	tests := []struct {
+		name    string
+		turns   []*provider.FakeTurn
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			turns: []*provider.FakeTurn{{Response: "```json\n{\"name\": \"x\"}\n```"}},
+			want:  `{"name": "x"}`,
+		},
+		{
+			name: "retried",
+			turns: []*provider.FakeTurn{
+				{Response: "Sure, here it is: {\"age\": 1}"},
+				{Response: "not JSON at all"},
+				{Response: "{\"name\": \"x\"}"},
+			},
+			want: `{"name": "x"}`,
+		},
+		{
+			name: "out of retries",
+			turns: []*provider.FakeTurn{
+				{Response: "{\"age\": 1}"},
+				{Response: "{\"age\": 2}"},
+				{Response: "{\"age\": 3}"},
+			},
+			wantErr: "no valid JSON after 3 attempts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recordingProvider{Provider: useFake(t, tt.turns...)}
+			prov = rec
+
+			var out bytes.Buffer
+			msgs := []*provider.Message{{Role: provider.User, Content: "make a person"}}
+			got, err := generateJSON(context.Background(), parser.MultiWriter(&out), msgs, schema)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				if out.Len() > 0 {
+					t.Errorf("invalid JSON was written: %q", out.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || out.String() != tt.want+"\n" {
+				t.Errorf("generateJSON = %q, output %q, want %q", got, out.String(), tt.want)
+			}
+			if len(rec.requests) != len(tt.turns) {
+				t.Fatalf("%d requests, want %d", len(rec.requests), len(tt.turns))
+			}
+			// each retry sends back the invalid response and what's wrong with it
+			for i, req := range rec.requests[1:] {
+				tail := req[len(req)-2:]
+				if tail[0].Role != provider.Assistant || tail[0].Content != tt.turns[i].Response {
+					t.Errorf("retry %d doesn't include previous response: %+v", i+1, tail[0])
+				}
+				if tail[1].Role != provider.User || !strings.HasPrefix(tail[1].Content, "Your response is not valid") {
+					t.Errorf("retry %d doesn't ask to fix the response: %+v", i+1, tail[1])
+				}
+			}
+			if req := rec.requests[0]; req[0].Role != provider.System || !strings.Contains(req[0].Content, `"required": ["name"]`) {
+				t.Errorf("schema isn't passed to the model: %+v", req[0])
+			}
+		})
+	}
+}
+
+func TestKeepFailedCode(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags *flagValues
+	}{
+		{name: "run", flags: &flagValues{Run: true}},
+		{name: "execute", flags: &flagValues{Execute: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFake(t, &provider.FakeTurn{Response: "```bash\nexit 4\n```"})
+			// working directory is created by executor, so it's removed unless kept
+			t.Setenv("TMPDIR", t.TempDir())
+			codeExec := &executor{}
+			turnFn := codeTurn(tt.flags, codeExec, nil)
+
+			var out bytes.Buffer
+			msgs := []*provider.Message{{Role: provider.User, Content: "fail"}}
+			_, err := turnFn(context.Background(), parser.MultiWriter(&out), msgs)
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != 4 {
+				t.Errorf("turn error = %v, want exit status 4", err)
+			}
+			if err := codeExec.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(codeExec.workDir); err != nil {
+				t.Errorf("working directory of failed code was removed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/daulet/cmd/provider"
+)
+
+type modelListing struct {
+	*provider.Model
+	// Selected lists model types this model is selected for
+	Selected []string `json:"selected,omitempty"`
+}
+
+// listModels prints models available from the provider, enriched with registry info.
+func listModels(ctx context.Context, asJSON bool) error {
+	models, err := prov.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+
+	// provider defaults are used for model types not set in config
+	selected := provider.DefaultModels(cfg)
+	if selected == nil {
+		selected = make(map[string]string)
+	}
+	for modelType, model := range cfg.Model {
+		if model != "" {
+			selected[modelType] = model
+		}
+	}
+
+	var listings []*modelListing
+	for _, model := range models {
+		if info, err := cfg.LookupModel(model.ID); err == nil {
+			if len(model.Capabilities) == 0 {
+				model.Capabilities = info.Capabilities
+			}
+			if model.ContextLength == 0 {
+				model.ContextLength = info.ContextWindow
+			}
+		}
+		listing := &modelListing{Model: model}
+		for modelType, selected := range selected {
+			if selected == model.ID {
+				listing.Selected = append(listing.Selected, modelType)
+			}
+		}
+		slices.Sort(listing.Selected)
+		listings = append(listings, listing)
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(listings, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tOWNER\tCONTEXT\tCAPABILITIES\tSTATUS\tSELECTED")
+	for _, listing := range listings {
+		contextLength := "-"
+		if listing.ContextLength > 0 {
+			contextLength = fmt.Sprint(listing.ContextLength)
+		}
+		var caps []string
+		for _, c := range listing.Capabilities {
+			caps = append(caps, string(c))
+		}
+		status := "active"
+		if !listing.Active {
+			status = "deprecated"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			listing.ID,
+			listing.Owner,
+			contextLength,
+			strings.Join(caps, ","),
+			status,
+			strings.Join(listing.Selected, ","),
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	for _, modelType := range slices.Sorted(maps.Keys(selected)) {
+		model := selected[modelType]
+		if cfg.Model[modelType] == "" {
+			model += " (provider default)"
+		}
+		fmt.Printf("Currently selected model for %s: %s\n", modelType, model)
+	}
+	return nil
+}
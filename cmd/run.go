@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/daulet/cmd/config"
+	"github.com/daulet/cmd/parser"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// WORKDIR_PATTERN names temporary directories generated code is written to
+	WORKDIR_PATTERN = "cmd-*"
+)
+
+// blockFile is a file written to run a code block.
+type blockFile struct {
+	path string
+	code string
+}
+
+// blockPlan is what running a code block does, so it can be shown before it's done.
+type blockPlan struct {
+	// lang of the runner, or tag of the block when there is no runner for it
+	lang string
+	// dir is where files are written, the only writable directory in sandbox
+	dir   string
+	files []*blockFile
+	// build is empty when code is run without compiling it first
+	build []string
+	// command is empty when block is only written, e.g. to be used by other blocks
+	command []string
+	env     []string
+	// sandbox is nil when command runs unrestricted
+	sandbox *config.SandboxConfig
+	// output of commands is also written to it when set
+	output io.Writer
+}
+
+// planBlock decides how code block is run by runner of its language, blocks without one aren't run.
+func planBlock(block *parser.CodeBlock, dir string) *blockPlan {
+	runner, err := cfg.LookupRunner(block.Tag)
+	if err != nil {
+		return &blockPlan{lang: block.Tag, dir: dir}
+	}
+	code := block.Code
+	// TODO remove when prompt engineering is there to add "make it runnable"
+	if runner.Lang == "go" && !strings.HasPrefix(code, "package") {
+		code = fmt.Sprintf("package main\n\n%s", code)
+	}
+	plan := &blockPlan{lang: runner.Lang, dir: dir}
+	var path string
+	if runner.File != "" {
+		path = filepath.Join(dir, runner.File)
+		plan.files = []*blockFile{{path: path, code: code}}
+	}
+	r := strings.NewReplacer("{file}", path, "{dir}", dir, "{code}", code)
+	plan.build = expandArgs(r, runner.Build)
+	plan.command = expandArgs(r, runner.Command)
+	for _, key := range slices.Sorted(maps.Keys(runner.Env)) {
+		plan.env = append(plan.env, key+"="+r.Replace(runner.Env[key]))
+	}
+	return plan
+}
+
+func expandArgs(r *strings.Replacer, args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = r.Replace(arg)
+	}
+	return expanded
+}
+
+func (p *blockPlan) run(ctx context.Context) error {
+	for _, file := range p.files {
+		if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file.path, []byte(file.code), 0644); err != nil {
+			return err
+		}
+	}
+	if len(p.command) == 0 {
+		return nil
+	}
+	if len(p.build) > 0 {
+		if err := p.exec(ctx, p.build); err != nil {
+			return fmt.Errorf("failed to build %s code: %w", p.lang, err)
+		}
+	}
+	return p.exec(ctx, p.command)
+}
+
+func (p *blockPlan) exec(ctx context.Context, command []string) error {
+	var (
+		stdout io.Writer = os.Stdout
+		stderr io.Writer = &colorWriter{
+			Writer: os.Stderr,
+			Color:  color.New(color.FgHiRed),
+		}
+	)
+	if p.output != nil {
+		stdout = io.MultiWriter(stdout, p.output)
+		stderr = io.MultiWriter(stderr, p.output)
+	}
+	if p.sandbox != nil {
+		return runSandboxed(ctx, p.sandbox, p.dir, p.env, stdout, stderr, command)
+	}
+	return runCmd(ctx, p.env, stdout, stderr, command[0], command[1:]...)
+}
+
+// describe prints files that would be written and commands that would be run.
+func (p *blockPlan) describe(w io.Writer) {
+	for _, file := range p.files {
+		fmt.Fprintf(w, "would write %s (%d lines)\n", file.path, lineCount(file.code))
+	}
+	if len(p.command) == 0 {
+		fmt.Fprintln(w, "would not run anything")
+		return
+	}
+	if len(p.build) > 0 {
+		fmt.Fprintf(w, "would build: %s\n", shellQuote(p.build))
+	}
+	fmt.Fprintf(w, "would run: %s\n", shellQuote(p.command))
+	if len(p.env) > 0 {
+		fmt.Fprintf(w, "with env: %s\n", shellQuote(p.env))
+	}
+	if p.sandbox != nil {
+		network := "no network"
+		if p.sandbox.Network {
+			network = "with network"
+		}
+		fmt.Fprintf(w, "in sandbox: %s, writable %s, timeout %s, %d MB memory, %ds CPU\n",
+			network, strings.Join(append([]string{p.dir}, p.sandbox.Writable...), " "),
+			time.Duration(p.sandbox.Timeout), p.sandbox.MemoryMB, p.sandbox.CPUSeconds,
+		)
+	}
+}
+
+// showBlock prints code block with its language and size, followed by what running it does.
+func showBlock(w io.Writer, block *parser.CodeBlock, plan *blockPlan) {
+	header := color.New(color.FgCyan)
+	lang := plan.lang
+	if lang == "" {
+		lang = "unknown"
+	}
+	header.Fprintf(w, "--- %s, %d lines ---\n", lang, lineCount(block.Code))
+	fmt.Fprint(w, block.Code)
+	header.Fprintln(w, "---")
+	plan.describe(w)
+}
+
+// executor runs code blocks, or only shows them in dry run, asking for confirmation if reviewer is set.
+// Blocks of one invocation share working directory, so they can use each other's files.
+type executor struct {
+	// workDir is created on first run unless set
+	workDir  string
+	dryRun   bool
+	reviewer *reviewer
+	// sandbox all languages, not only those configured to be
+	sandbox bool
+	// keep working directory after successful run
+	keep   bool
+	failed bool
+	// capture output of code, so failures can be fixed
+	capture bool
+}
+
+// dir returns working directory, creating it if needed.
+func (e *executor) dir() (string, error) {
+	if e.dryRun {
+		// nothing is written in dry run, so directory is only named
+		if e.workDir != "" {
+			return e.workDir, nil
+		}
+		return filepath.Join(os.TempDir(), WORKDIR_PATTERN), nil
+	}
+	if e.workDir != "" {
+		return e.workDir, os.MkdirAll(e.workDir, 0755)
+	}
+	dir, err := os.MkdirTemp("", WORKDIR_PATTERN)
+	if err != nil {
+		return "", fmt.Errorf("failed to create working directory: %w", err)
+	}
+	e.workDir = dir
+	return dir, nil
+}
+
+func (e *executor) plan(block *parser.CodeBlock) *blockPlan {
+	dir, _ := e.dir()
+	plan := planBlock(block, dir)
+	plan.sandbox = sandboxFor(plan.lang, e.sandbox)
+	return plan
+}
+
+// TODO return correct exit code when -run or -exec fails - is this fixed?
+func (e *executor) run(ctx context.Context, block *parser.CodeBlock) error {
+	if _, err := e.dir(); err != nil {
+		return err
+	}
+	if e.dryRun {
+		showBlock(os.Stdout, block, e.plan(block))
+		return nil
+	}
+	if e.reviewer != nil {
+		var err error
+		block, err = e.reviewer.review(block, e.plan)
+		if err != nil || block == nil {
+			return err
+		}
+	}
+	plan := e.plan(block)
+	var output *tailWriter
+	if e.capture {
+		output = &tailWriter{limit: OUTPUT_TAIL_LEN}
+		plan.output = output
+	}
+	if err := plan.run(ctx); err != nil {
+		e.failed = true
+		runErr := &runError{lang: plan.lang, err: err}
+		if output != nil {
+			runErr.output = string(output.buf)
+		}
+		return runErr
+	}
+	return nil
+}
+
+// Close removes working directory, unless it's kept or code failed, so it can be inspected.
+func (e *executor) Close() error {
+	if e.workDir == "" || e.dryRun {
+		return nil
+	}
+	switch {
+	case e.failed:
+		color.New(color.FgYellow).Fprintf(os.Stderr, "code failed, files are kept in %s\n", e.workDir)
+		return nil
+	case e.keep:
+		color.New(color.FgHiBlack).Fprintf(os.Stderr, "files are kept in %s\n", e.workDir)
+		return nil
+	}
+	return os.RemoveAll(e.workDir)
+}
+
+func runCmd(ctx context.Context, env []string, stdout, stderr io.Writer, prog string, args ...string) error {
+	cmd := exec.CommandContext(ctx, prog, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+func lineCount(code string) int {
+	return strings.Count(strings.TrimRight(code, "\n"), "\n") + 1
+}
+
+// shellQuote formats command so it can be copied into a shell.
+func shellQuote(command []string) string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
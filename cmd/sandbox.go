@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/daulet/cmd/config"
+)
+
+const (
+	// SANDBOX_INIT_ARG runs cmd as sandbox init process that restricts itself and runs the command
+	SANDBOX_INIT_ARG = "__sandbox_init"
+	// SANDBOX_SPEC_ENV passes sandbox restrictions to init process
+	SANDBOX_SPEC_ENV = "CMD_SANDBOX_SPEC"
+	// SANDBOX_SETUP_EXIT_CODE is exit code of init process that failed to set up the sandbox
+	SANDBOX_SETUP_EXIT_CODE = 125
+
+	DEFAULT_SANDBOX_TIMEOUT     = time.Minute
+	DEFAULT_SANDBOX_MEMORY_MB   = 1024
+	DEFAULT_SANDBOX_CPU_SECONDS = 60
+
+	// how much of stderr is kept to explain sandbox failures
+	STDERR_TAIL_LEN = 4096
+)
+
+// sandboxEnvNames are environment variables passed to sandboxed code, the rest is
+// left out since it may hold secrets like API keys.
+var sandboxEnvNames = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "TZ", "LANG", "LANGUAGE", "XDG_CACHE_HOME"}
+
+// sandboxSpec is what init process applies to itself before running the command.
+type sandboxSpec struct {
+	Writable   []string `json:"writable"`
+	MemoryMB   int      `json:"memory_mb"`
+	CPUSeconds int      `json:"cpu_seconds"`
+}
+
+// sandboxFor returns sandbox settings of the language with defaults filled in,
+// nil when code of the language runs unrestricted.
+func sandboxFor(lang string, enabled bool) *config.SandboxConfig {
+	sb := cfg.SandboxFor(lang)
+	if sb == nil {
+		if !enabled {
+			return nil
+		}
+		sb = &config.SandboxConfig{}
+	}
+	res := *sb
+	if res.Timeout == 0 {
+		res.Timeout = config.Duration(DEFAULT_SANDBOX_TIMEOUT)
+	}
+	if res.MemoryMB == 0 {
+		res.MemoryMB = DEFAULT_SANDBOX_MEMORY_MB
+	}
+	if res.CPUSeconds == 0 {
+		res.CPUSeconds = DEFAULT_SANDBOX_CPU_SECONDS
+	}
+	if lang == "go" && len(sb.Writable) == 0 {
+		// go run needs build cache, otherwise it rebuilds standard library every time
+		if cache, err := goCache(); err == nil {
+			res.Writable = []string{cache}
+		}
+	}
+	if lang == "go" {
+		res.Env = append(slices.Clone(sb.Env), "GOCACHE", "GOPATH", "GOROOT", "GOFLAGS")
+	}
+	return &res
+}
+
+// sandboxEnv is environment of sandboxed code: basic variables and ones allowed
+// by sandbox config from the host, followed by runner env.
+func sandboxEnv(sb *config.SandboxConfig, env []string) []string {
+	var res []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(sandboxEnvNames, name) || strings.HasPrefix(name, "LC_") || slices.Contains(sb.Env, name) {
+			res = append(res, kv)
+		}
+	}
+	return append(res, env...)
+}
+
+func goCache() (string, error) {
+	if cache := os.Getenv("GOCACHE"); cache != "" {
+		return cache, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-build"), nil
+}
+
+// writablePaths resolves writable paths of the sandbox, creating missing ones
+// since only existing paths can be made writable.
+func writablePaths(sb *config.SandboxConfig, scratch string) ([]string, error) {
+	paths := []string{scratch}
+	for _, path := range sb.Writable {
+		if rest, ok := strings.CutPrefix(path, "~"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.Join(home, rest)
+		}
+		paths = append(paths, path)
+	}
+	for i, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(abs, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create writable path of sandbox: %w", err)
+		}
+		paths[i] = abs
+	}
+	return paths, nil
+}
+
+// runSandboxed runs command in sandbox from current directory, which is read only
+// like the rest of file system, scratch is writable and is passed as TMPDIR.
+func runSandboxed(ctx context.Context, sb *config.SandboxConfig, scratch string, env []string, stdout, stderr io.Writer, command []string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(sb.Timeout))
+	defer cancel()
+	cmd, err := sandboxCommand(ctx, sb, scratch, env, command[0], command[1:]...)
+	if err != nil {
+		return err
+	}
+	tail := &tailWriter{limit: STDERR_TAIL_LEN}
+	cmd.Stdout = stdout
+	cmd.Stderr = io.MultiWriter(stderr, tail)
+	if err := cmd.Run(); err != nil {
+		return sandboxError(ctx, err, sb, scratch, string(tail.buf))
+	}
+	return nil
+}
+
+// tailWriter keeps the last limit bytes written to it.
+type tailWriter struct {
+	limit int
+	buf   []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.limit {
+		w.buf = w.buf[len(w.buf)-w.limit:]
+	}
+	return len(p), nil
+}
+
+// sandboxError explains failure of sandboxed command when it was likely caused by the sandbox.
+func sandboxError(ctx context.Context, err error, sb *config.SandboxConfig, scratch string, stderr string) error {
+	if err := setupError(err, stderr); err != nil {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("sandbox: killed after timeout of %s: %w", time.Duration(sb.Timeout), err)
+	}
+	if cpuLimitExceeded(err) {
+		return fmt.Errorf("sandbox: CPU time limit of %ds exceeded: %w", sb.CPUSeconds, err)
+	}
+	switch {
+	case strings.Contains(stderr, "Read-only file system"):
+		return fmt.Errorf("sandbox: blocked writing outside of %s, add path to \"writable\" of sandbox config: %w", scratch, err)
+	case !sb.Network && (strings.Contains(stderr, "Network is unreachable") ||
+		strings.Contains(stderr, "Temporary failure in name resolution") ||
+		strings.Contains(stderr, "Could not resolve host") ||
+		strings.Contains(stderr, "no such host")):
+		return fmt.Errorf("sandbox: blocked network access, set \"network\": true in sandbox config to allow it: %w", err)
+	case strings.Contains(stderr, "Cannot allocate memory") ||
+		strings.Contains(stderr, "MemoryError") ||
+		strings.Contains(stderr, "out of memory"):
+		return fmt.Errorf("sandbox: memory limit of %d MB exceeded: %w", sb.MemoryMB, err)
+	}
+	return err
+}
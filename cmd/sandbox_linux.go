@@ -0,0 +1,243 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/daulet/cmd/config"
+)
+
+// sandboxCommand runs command in new user, mount, PID and network namespaces via
+// cmd itself as init process, which makes file system read only and applies limits.
+func sandboxCommand(ctx context.Context, sb *config.SandboxConfig, scratch string, env []string, prog string, args ...string) (*exec.Cmd, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	writable, err := writablePaths(sb, scratch)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := json.Marshal(&sandboxSpec{
+		Writable:   writable,
+		MemoryMB:   sb.MemoryMB,
+		CPUSeconds: sb.CPUSeconds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// resolved here, so init only fails to set up the sandbox
+	prog, err = exec.LookPath(prog)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, self, append([]string{SANDBOX_INIT_ARG, prog}, args...)...)
+	cmd.Env = append(sandboxEnv(sb, env),
+		SANDBOX_SPEC_ENV+"="+string(spec),
+		"TMPDIR="+scratch,
+	)
+	cloneflags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS)
+	if !sb.Network {
+		cloneflags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: cloneflags,
+		// root in the namespace has capabilities to set up mounts, outside it's the user
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		Pdeathsig:                  syscall.SIGKILL,
+	}
+	return cmd, nil
+}
+
+// sandboxInit restricts the process according to the spec and replaces it with the command.
+func sandboxInit() error {
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(os.Getenv(SANDBOX_SPEC_ENV)), &spec); err != nil {
+		return fmt.Errorf("invalid sandbox spec: %w", err)
+	}
+	os.Unsetenv(SANDBOX_SPEC_ENV)
+	if len(os.Args) < 3 {
+		return fmt.Errorf("no command to run in sandbox")
+	}
+
+	// changes must not propagate to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+	// writable paths become separate mounts, so they are left alone below
+	for _, path := range spec.Writable {
+		if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to bind %s: %w", path, err)
+		}
+	}
+	if err := remountReadOnly(spec.Writable); err != nil {
+		return err
+	}
+	// working directory still points below the mounts made above
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(wd); err != nil {
+		return err
+	}
+
+	if spec.MemoryMB > 0 {
+		limit := uint64(spec.MemoryMB) << 20
+		if err := syscall.Setrlimit(syscall.RLIMIT_DATA, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return fmt.Errorf("failed to limit memory: %w", err)
+		}
+	}
+	if spec.CPUSeconds > 0 {
+		limit := uint64(spec.CPUSeconds)
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			return fmt.Errorf("failed to limit CPU time: %w", err)
+		}
+	}
+
+	err = syscall.Exec(os.Args[2], os.Args[2:], os.Environ())
+	// sandbox is set up by now, so it's reported like shells do
+	fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[2], err)
+	os.Exit(126)
+	return nil
+}
+
+// setupError explains failure to create the sandbox, either to start the process in new
+// namespaces or to set it up in the init process, nil when err is failure of the command.
+func setupError(err error, stderr string) error {
+	var (
+		pathErr *os.PathError
+		exitErr *exec.ExitError
+		reason  string
+	)
+	switch {
+	case errors.As(err, &pathErr) && pathErr.Op == "fork/exec" &&
+		(errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EINVAL)):
+		reason = err.Error()
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == SANDBOX_SETUP_EXIT_CODE:
+		// init reports what failed as the last line of stderr
+		lines := strings.Split(strings.TrimSpace(stderr), "\n")
+		reason = strings.TrimPrefix(lines[len(lines)-1], "sandbox: ")
+	default:
+		return nil
+	}
+	return fmt.Errorf("sandbox: could not be created (%s), %s: %w", reason, namespaceHint(), err)
+}
+
+// namespaceHint tells why unprivileged user namespaces are likely unavailable.
+func namespaceHint() string {
+	sysctl := func(name string) string {
+		data, _ := os.ReadFile("/proc/sys/" + name)
+		return strings.TrimSpace(string(data))
+	}
+	switch {
+	case sysctl("kernel/unprivileged_userns_clone") == "0":
+		return "unprivileged user namespaces are disabled, enable them with sysctl kernel.unprivileged_userns_clone=1"
+	case sysctl("kernel/apparmor_restrict_unprivileged_userns") == "1":
+		return "AppArmor restricts unprivileged user namespaces, allow them with sysctl kernel.apparmor_restrict_unprivileged_userns=0 or an AppArmor profile for cmd"
+	case sysctl("user/max_user_namespaces") == "0":
+		return "user namespaces are disabled, allow them with sysctl user.max_user_namespaces"
+	}
+	return "unprivileged user namespaces are likely unavailable, e.g. blocked by seccomp profile of a container"
+}
+
+// remountReadOnly makes all mounts read only except writable ones. Pseudo file
+// systems may refuse it, which is fine since they aren't writable without privileges.
+func remountReadOnly(writable []string) error {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// see proc(5): id parent major:minor root mount-point options ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		mountPoint := unescapeMount(fields[4])
+		if isWritable(mountPoint, writable) {
+			continue
+		}
+		// flags that are locked in user namespace have to be kept
+		flags := uintptr(syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_RDONLY)
+		for _, opt := range strings.Split(fields[5], ",") {
+			switch opt {
+			case "nosuid":
+				flags |= syscall.MS_NOSUID
+			case "nodev":
+				flags |= syscall.MS_NODEV
+			case "noexec":
+				flags |= syscall.MS_NOEXEC
+			case "noatime":
+				flags |= syscall.MS_NOATIME
+			case "nodiratime":
+				flags |= syscall.MS_NODIRATIME
+			case "relatime":
+				flags |= syscall.MS_RELATIME
+			}
+		}
+		err := syscall.Mount("", mountPoint, "", flags, "")
+		if err != nil && !isPseudoMount(mountPoint) && !errors.Is(err, syscall.ENOENT) {
+			return fmt.Errorf("failed to make %s read only: %w", mountPoint, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// isWritable reports whether mount point is one of writable paths or is inside one.
+func isWritable(mountPoint string, writable []string) bool {
+	for _, path := range writable {
+		if mountPoint == path || strings.HasPrefix(mountPoint, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func isPseudoMount(mountPoint string) bool {
+	for _, prefix := range []string{"/proc", "/sys", "/dev"} {
+		if mountPoint == prefix || strings.HasPrefix(mountPoint, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeMount decodes octal escapes of spaces and such in mount points.
+func unescapeMount(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func cpuLimitExceeded(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGXCPU
+}
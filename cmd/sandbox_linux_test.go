@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSandboxSetupError(t *testing.T) {
+	exitErr := func(code string) error {
+		return exec.Command("sh", "-c", "exit "+code).Run()
+	}
+	tests := []struct {
+		name   string
+		err    error
+		stderr string
+		want   string
+	}{
+		{
+			name: "namespaces unavailable",
+			err:  &os.PathError{Op: "fork/exec", Path: "/usr/bin/cmd", Err: syscall.EPERM},
+			want: "sandbox: could not be created (fork/exec /usr/bin/cmd: operation not permitted)",
+		},
+		{
+			name:   "init failed",
+			err:    exitErr("125"),
+			stderr: "sandbox: failed to make mounts private: operation not permitted\n",
+			want:   "sandbox: could not be created (failed to make mounts private: operation not permitted)",
+		},
+		{name: "command failed", err: exitErr("1"), stderr: "sandbox: not from init\n"},
+		{name: "command not found", err: exec.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := setupError(tt.err, tt.stderr)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("setupError = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("setupError = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/daulet/cmd/config"
+)
+
+func sandboxCommand(ctx context.Context, sb *config.SandboxConfig, scratch string, env []string, prog string, args ...string) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("sandbox is only supported on Linux")
+}
+
+func sandboxInit() error {
+	return fmt.Errorf("sandbox is only supported on Linux")
+}
+
+func setupError(err error, stderr string) error {
+	return nil
+}
+
+func cpuLimitExceeded(err error) bool {
+	return false
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/daulet/cmd/config"
+)
+
+func TestSandboxEnv(t *testing.T) {
+	t.Setenv("PATH", "/usr/bin")
+	t.Setenv("LC_ALL", "C")
+	t.Setenv("GROQ_API_KEY", "secret")
+	t.Setenv("OPENAI_API_KEY", "secret")
+	t.Setenv("VIRTUAL_ENV", "/venv")
+
+	env := sandboxEnv(&config.SandboxConfig{Env: []string{"VIRTUAL_ENV"}}, []string{"GOOS=linux"})
+	for _, want := range []string{"PATH=/usr/bin", "LC_ALL=C", "VIRTUAL_ENV=/venv", "GOOS=linux"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("env %q is missing %s", env, want)
+		}
+	}
+	for _, leaked := range []string{"GROQ_API_KEY=secret", "OPENAI_API_KEY=secret"} {
+		if slices.Contains(env, leaked) {
+			t.Errorf("env %q leaks %s", env, leaked)
+		}
+	}
+}
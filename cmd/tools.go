@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/daulet/cmd/provider"
+
+	"github.com/fatih/color"
+)
+
+const maxToolOutput = 256 * 1024
+
+// read only git subcommands model is allowed to run
+var gitSubcommands = []string{"blame", "describe", "diff", "log", "ls-files", "rev-parse", "shortlog", "show", "status"}
+
+// gitFlags model is allowed to pass, any other flag could read or write files outside
+// the current directory or run programs, e.g. --no-index, --output, --ext-diff or -c
+var gitFlags = []string{
+	"--abbrev", "--abbrev-commit", "--abbrev-ref", "--after", "--all", "--always", "--ancestry-path",
+	"--author", "--before", "--branch", "--cached", "--committer", "--count", "--date", "--decorate",
+	"--diff-filter", "--dirstat", "--first-parent", "--follow", "--format", "--full-history", "--graph",
+	"--grep", "--ignore-all-space", "--ignore-blank-lines", "--ignore-space-change", "--long", "--max-count",
+	"--merges", "--modified", "--name-only", "--name-status", "--no-color", "--no-merges", "--no-patch",
+	"--numstat", "--oneline", "--others", "--patch", "--porcelain", "--pretty", "--raw", "--reverse",
+	"--short", "--shortstat", "--show-toplevel", "--since", "--skip", "--staged", "--stat", "--summary",
+	"--tags", "--until", "--unified", "--verify", "--word-diff",
+	"-U", "-b", "-i", "-n", "-p", "-s", "-w",
+}
+
+// numericGitFlag matches flags with number attached, e.g. -5 or -n5 or -U10
+var numericGitFlag = regexp.MustCompile(`^-[nU]?[0-9]+$`)
+
+// builtinTools gives model read only access to the current directory.
+func builtinTools() []*provider.ToolDefinition {
+	return []*provider.ToolDefinition{
+		{
+			Name:        "read_file",
+			Description: "Read a file in the current directory.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"path":{"type":"string","description":"Path relative to the current directory."}},"required":["path"]}`),
+			Handler:     logTool("read_file", readFileTool),
+		},
+		{
+			Name:        "list_directory",
+			Description: "List files in a directory, directories have trailing slash.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"path":{"type":"string","description":"Path relative to the current directory, defaults to the current directory."}}}`),
+			Handler:     logTool("list_directory", listDirectoryTool),
+		},
+		{
+			Name:        "git",
+			Description: fmt.Sprintf("Run read only git command in the current directory, allowed subcommands: %s.", strings.Join(gitSubcommands, ", ")),
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"args":{"type":"array","items":{"type":"string"},"description":"Arguments to git, starting with subcommand."}},"required":["args"]}`),
+			Handler:     logTool("git", gitTool),
+		},
+	}
+}
+
+// logTool reports tool calls to the user without polluting stdout.
+func logTool(name string, handler func(context.Context, json.RawMessage) (string, error)) func(context.Context, json.RawMessage) (string, error) {
+	return func(ctx context.Context, args json.RawMessage) (string, error) {
+		color.New(color.FgCyan).Fprintf(os.Stderr, "tool %s %s\n", name, args)
+		return handler(ctx, args)
+	}
+}
+
+// localPath resolves path and makes sure it doesn't escape the current directory.
+func localPath(path string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		path = "."
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(wd); err == nil {
+		wd = resolved
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the current directory", path)
+	}
+	return abs, nil
+}
+
+func readFileTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	path, err := localPath(params.Path)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxToolOutput {
+		return fmt.Sprintf("%s\n... truncated, file is %d bytes", data[:maxToolOutput], len(data)), nil
+	}
+	return string(data), nil
+}
+
+func listDirectoryTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	path, err := localPath(params.Path)
+	if err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	for _, entry := range entries {
+		out.WriteString(entry.Name())
+		if entry.IsDir() {
+			out.WriteString("/")
+		}
+		out.WriteString("\n")
+	}
+	return out.String(), nil
+}
+
+func gitTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Args []string `json:"args"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	if err := checkGitArgs(params.Args); err != nil {
+		return "", err
+	}
+	gitArgs := []string{"--no-pager", params.Args[0]}
+	switch params.Args[0] {
+	case "diff", "log", "show":
+		// external diff and textconv drivers from user config run programs
+		gitArgs = append(gitArgs, "--no-ext-diff", "--no-textconv")
+	}
+	gitArgs = append(gitArgs, params.Args[1:]...)
+	cmd := exec.CommandContext(ctx, "git", gitArgs...)
+	out, err := cmd.CombinedOutput()
+	if len(out) > maxToolOutput {
+		out = append(out[:maxToolOutput], "\n... truncated"...)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, out)
+	}
+	return string(out), nil
+}
+
+// checkGitArgs allows only read only subcommands with known flags, and paths inside
+// the current directory: git diff of path outside of repository reads any file.
+func checkGitArgs(args []string) error {
+	if len(args) == 0 || !slices.Contains(gitSubcommands, args[0]) {
+		return fmt.Errorf("allowed git subcommands: %s", strings.Join(gitSubcommands, ", "))
+	}
+	paths := false
+	for _, arg := range args[1:] {
+		switch {
+		case paths:
+			if _, err := localPath(arg); err != nil {
+				return err
+			}
+		case arg == "--":
+			paths = true
+		case strings.HasPrefix(arg, "-"):
+			name, _, _ := strings.Cut(arg, "=")
+			if !slices.Contains(gitFlags, name) && !numericGitFlag.MatchString(arg) {
+				return fmt.Errorf("git flag %s is not allowed", name)
+			}
+		case isPathArg(arg):
+			// otherwise it's a revision, which can't reach outside of repository
+			if _, err := localPath(arg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isPathArg reports whether argument could be a path rather than a revision.
+func isPathArg(arg string) bool {
+	if filepath.IsAbs(arg) || slices.Contains(strings.Split(filepath.ToSlash(arg), "/"), "..") {
+		return true
+	}
+	_, err := os.Lstat(arg)
+	return err == nil
+}
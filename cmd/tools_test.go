@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCheckGitArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		ok   bool
+	}{
+		{args: []string{"log", "--oneline", "-5"}, ok: true},
+		{args: []string{"log", "-n", "2", "--format=%h %s", "HEAD~3..HEAD", "--", "."}, ok: true},
+		{args: []string{"show", "--stat", "HEAD:main.go"}, ok: true},
+		{args: []string{"diff", "-U10", "--cached"}, ok: true},
+		{args: []string{"status", "--short"}, ok: true},
+		{args: []string{}},
+		{args: []string{"push"}},
+		{args: []string{"config", "--list"}},
+		{args: []string{"diff", "--no-index", "a", "b"}},
+		{args: []string{"diff", "/etc/hostname", "/dev/null"}},
+		{args: []string{"diff", "--", "../secret"}},
+		{args: []string{"diff", "HEAD", "--", "/etc/passwd"}},
+		{args: []string{"diff", "--ext-diff"}},
+		{args: []string{"log", "--textconv"}},
+		{args: []string{"log", "-c", "core.pager=sh"}},
+		{args: []string{"log", "--output=/tmp/x"}},
+	}
+	for _, tt := range tests {
+		err := checkGitArgs(tt.args)
+		if tt.ok && err != nil {
+			t.Errorf("checkGitArgs(%q) = %v, want nil", tt.args, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("checkGitArgs(%q) = nil, want error", tt.args)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/daulet/cmd/config"
+	"github.com/daulet/cmd/provider"
+
+	"github.com/fatih/color"
+)
+
+// reportUsage records usage in the ledger and prints it with --stats. Failure
+// to record is only a warning, since the request itself succeeded.
+func reportUsage(usage *provider.Usage) {
+	if usage == nil {
+		return
+	}
+	model := usage.Model
+	if model == "" {
+		model = "default"
+	}
+	providerName := usage.Provider
+	if providerName == "" {
+		providerName = cfg.Provider
+	}
+	cost := cfg.Cost(model, usage.PromptTokens, usage.CompletionTokens)
+	if printStats {
+		color.New(color.FgHiBlack).Fprintf(os.Stderr,
+			"model: %s, prompt tokens: %d, completion tokens: %d, time to first token: %s, cost: $%.6f\n",
+			model, usage.PromptTokens, usage.CompletionTokens, usage.TimeToFirstToken.Round(time.Millisecond), cost,
+		)
+	}
+	err := config.AppendUsage(&config.UsageEntry{
+		Time:             time.Now(),
+		Provider:         providerName,
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             cost,
+	})
+	if err != nil {
+		color.New(color.FgYellow).Fprintf(os.Stderr, "warning: failed to record usage: %v\n", err)
+	}
+}
+
+// showUsage summarizes the usage ledger by day and model.
+func showUsage() error {
+	entries, err := config.ReadUsage()
+	if err != nil {
+		return err
+	}
+	type key struct {
+		day   string
+		model string
+	}
+	type total struct {
+		requests         int
+		promptTokens     int
+		completionTokens int
+		cost             float64
+	}
+	var (
+		keys   []key
+		totals = make(map[key]*total)
+		sum    = &total{}
+	)
+	for _, entry := range entries {
+		k := key{day: entry.Time.Local().Format(time.DateOnly), model: entry.Model}
+		t, ok := totals[k]
+		if !ok {
+			t = &total{}
+			totals[k] = t
+			keys = append(keys, k)
+		}
+		for _, t := range []*total{t, sum} {
+			t.requests++
+			t.promptTokens += entry.PromptTokens
+			t.completionTokens += entry.CompletionTokens
+			t.cost += entry.Cost
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].day != keys[j].day {
+			return keys[i].day < keys[j].day
+		}
+		return keys[i].model < keys[j].model
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "DAY\tMODEL\tREQUESTS\tPROMPT\tCOMPLETION\tCOST\t")
+	for _, k := range keys {
+		t := totals[k]
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t$%.4f\t\n", k.day, k.model, t.requests, t.promptTokens, t.completionTokens, t.cost)
+	}
+	fmt.Fprintf(w, "TOTAL\t\t%d\t%d\t%d\t$%.4f\t\n", sum.requests, sum.promptTokens, sum.completionTokens, sum.cost)
+	return w.Flush()
+}
@@ -7,11 +7,18 @@ import (
 	"path/filepath"
 )
 
-const configPath = ".cmd/config.json"
+const (
+	configPath       = ".cmd/config.json"
+	defaultCachePath = ".cmd/cache.json"
+)
 
 const (
-	ProviderGroq   = "groq"
-	ProviderCohere = "cohere"
+	ProviderGroq             = "groq"
+	ProviderCohere           = "cohere"
+	ProviderOpenAICompatible = "openai-compatible"
+	ProviderAnthropic        = "anthropic"
+	ProviderOllama           = "ollama"
+	ProviderFake             = "fake"
 
 	ModelTypeChat         = "chat"
 	ModelTypeChatImage    = "chat-image"
@@ -21,9 +28,51 @@ const (
 type Config struct {
 	Provider string `json:"provider,omitempty"`
 
-	Record     bool              `json:"record,omitempty"`
+	// OpenAICompatible is used when Provider is ProviderOpenAICompatible
+	OpenAICompatible *OpenAICompatibleConfig `json:"openai_compatible,omitempty"`
+	// FakeScript is a path to JSON script used when Provider is ProviderFake
+	FakeScript string `json:"fake_script,omitempty"`
+
+	// Record caches transcriptions
+	Record bool `json:"record,omitempty"`
+	// CacheChats caches chat responses too, so the same request replays the same answer
+	CacheChats bool `json:"cache_chats,omitempty"`
+	// Replay only serves cached responses and fails on cache miss
+	Replay bool `json:"replay,omitempty"`
+	// CachePath defaults to ~/.cmd/cache.json
+	CachePath string `json:"cache_path,omitempty"`
+
 	Model      map[string]string `json:"model,omitempty"`
 	Connectors []string          `json:"connectors,omitempty"`
+	// Models describe models missing from built-in registry or override them
+	Models []*ModelInfo `json:"models,omitempty"`
+
+	// Confirm before running generated code, like --confirm
+	Confirm bool `json:"confirm,omitempty"`
+	// Runners of code blocks in languages missing from built-in registry or overriding them
+	Runners []*Runner `json:"runners,omitempty"`
+
+	// Sandbox runs generated code restricted, keyed by language or SandboxDefault for the rest
+	Sandbox map[string]*SandboxConfig `json:"sandbox,omitempty"`
+
+	// ContextBudget is max tokens of --context directories, defaults to half of model context window
+	ContextBudget int `json:"context_budget,omitempty"`
+
+	// ChunkSize is max tokens of input chunks with --chunk, defaults to quarter of model context window
+	ChunkSize int `json:"chunk_size,omitempty"`
+	// ChunkConcurrency is how many chunks are processed at once
+	ChunkConcurrency int `json:"chunk_concurrency,omitempty"`
+
+	// Fallback providers tried in order when Provider is rate limited or down
+	Fallback []*FallbackConfig `json:"fallback,omitempty"`
+
+	// RateLimits keyed by "provider/model", or "provider" for all its models
+	RateLimits map[string]*RateLimit `json:"rate_limits,omitempty"`
+
+	// Retry of rate limited and failed requests, enabled with defaults when not set
+	Retry *RetryConfig `json:"retry,omitempty"`
+
+	SystemPrompt string `json:"system_prompt,omitempty"`
 
 	// Sampling parameters
 	Temperature      *float64 `json:"temperature,omitempty"`
@@ -31,6 +80,36 @@ type Config struct {
 	TopK             *int     `json:"top_k,omitempty"`
 	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
 	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	MaxTokens        *int     `json:"max_tokens,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+// OpenAICompatibleConfig describes any server implementing OpenAI API,
+// e.g. vLLM or llama.cpp.
+type OpenAICompatibleConfig struct {
+	BaseURL string `json:"base_url,omitempty"`
+	// APIKeyEnv is the name of env variable holding the API key,
+	// leave empty if the server doesn't require one.
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+	// DefaultModel is used for model types not set in Config.Model
+	DefaultModel map[string]string `json:"default_model,omitempty"`
+}
+
+type FallbackConfig struct {
+	Provider string `json:"provider"`
+	// Model by model type, otherwise provider defaults are used since configured models are of another provider
+	Model map[string]string `json:"model,omitempty"`
+}
+
+// RetryConfig controls retries with jittered exponential backoff,
+// set MaxAttempts to 1 to disable retries.
+type RetryConfig struct {
+	MaxAttempts    int      `json:"max_attempts,omitempty"`
+	InitialBackoff Duration `json:"initial_backoff,omitempty"`
+	MaxBackoff     Duration `json:"max_backoff,omitempty"`
+	// MaxElapsed is the time budget for all attempts
+	MaxElapsed Duration `json:"max_elapsed,omitempty"`
 }
 
 func ReadConfig() (*Config, error) {
@@ -71,12 +150,24 @@ func WriteConfig(cfg *Config) error {
 	return os.WriteFile(path, data, 0644)
 }
 
+// CacheFile is where responses are cached, configured path or the default in home directory.
+func (c *Config) CacheFile() (string, error) {
+	if c.CachePath != "" {
+		return c.CachePath, nil
+	}
+	return homePath(defaultCachePath)
+}
+
 func ConfigPath() (string, error) {
+	return homePath(configPath)
+}
+
+func homePath(path string) (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(homeDir, configPath), nil
+	return filepath.Join(homeDir, path), nil
 }
 
 func Ref(v string) *string {
@@ -2,22 +2,137 @@ package config
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 )
 
-func ModelType(model string) (string, error) {
+type Capability string
+
+const (
+	CapabilityText   Capability = "text"
+	CapabilityVision Capability = "vision"
+	CapabilityAudio  Capability = "audio"
+	CapabilityTools  Capability = "tools"
+	CapabilityJSON   Capability = "json"
+)
+
+// Pricing is in USD per million tokens.
+type Pricing struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+type ModelInfo struct {
+	ID       string `json:"id"`
+	Provider string `json:"provider,omitempty"`
+	// Type is the model type the model is selected for by --model
+	Type          string       `json:"type,omitempty"`
+	Capabilities  []Capability `json:"capabilities,omitempty"`
+	ContextWindow int          `json:"context_window,omitempty"`
+	Pricing       *Pricing     `json:"pricing,omitempty"`
+}
+
+func (m *ModelInfo) Has(c Capability) bool {
+	return slices.Contains(m.Capabilities, c)
+}
+
+// ModelType returns Type, or infers it from capabilities when it's not set.
+func (m *ModelInfo) ModelType() string {
+	switch {
+	case m.Type != "":
+		return m.Type
+	case m.Has(CapabilityAudio) && !m.Has(CapabilityText):
+		return ModelTypeSpeechToText
+	case m.Has(CapabilityVision) && !m.Has(CapabilityText):
+		return ModelTypeChatImage
+	default:
+		return ModelTypeChat
+	}
+}
+
+var (
+	chatCaps       = []Capability{CapabilityText, CapabilityTools, CapabilityJSON}
+	chatVisionCaps = []Capability{CapabilityText, CapabilityVision, CapabilityTools, CapabilityJSON}
+	audioCaps      = []Capability{CapabilityAudio}
+)
+
+// knownModels is the built-in registry, entries can be overridden with Config.Models.
+var knownModels = []*ModelInfo{
+	// Groq
+	{ID: "llama-3.1-8b-instant", Provider: ProviderGroq, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 131072, Pricing: &Pricing{Input: 0.05, Output: 0.08}},
+	{ID: "llama-3.1-70b-versatile", Provider: ProviderGroq, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 131072, Pricing: &Pricing{Input: 0.59, Output: 0.79}},
+	{ID: "llama-3.3-70b-versatile", Provider: ProviderGroq, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 131072, Pricing: &Pricing{Input: 0.59, Output: 0.79}},
+	{ID: "llama3-8b-8192", Provider: ProviderGroq, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 8192, Pricing: &Pricing{Input: 0.05, Output: 0.08}},
+	{ID: "llama3-70b-8192", Provider: ProviderGroq, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 8192, Pricing: &Pricing{Input: 0.59, Output: 0.79}},
+	{ID: "mixtral-8x7b-32768", Provider: ProviderGroq, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 32768, Pricing: &Pricing{Input: 0.24, Output: 0.24}},
+	{ID: "gemma2-9b-it", Provider: ProviderGroq, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 8192, Pricing: &Pricing{Input: 0.20, Output: 0.20}},
+	{ID: "llava-v1.5-7b-4096-preview", Provider: ProviderGroq, Type: ModelTypeChatImage, Capabilities: []Capability{CapabilityText, CapabilityVision}, ContextWindow: 4096},
+	{ID: "llama-3.2-11b-vision-preview", Provider: ProviderGroq, Type: ModelTypeChatImage, Capabilities: chatVisionCaps, ContextWindow: 8192, Pricing: &Pricing{Input: 0.18, Output: 0.18}},
+	{ID: "llama-3.2-90b-vision-preview", Provider: ProviderGroq, Type: ModelTypeChatImage, Capabilities: chatVisionCaps, ContextWindow: 8192, Pricing: &Pricing{Input: 0.90, Output: 0.90}},
+	{ID: "whisper-large-v3", Provider: ProviderGroq, Type: ModelTypeSpeechToText, Capabilities: audioCaps},
+	{ID: "whisper-large-v3-turbo", Provider: ProviderGroq, Type: ModelTypeSpeechToText, Capabilities: audioCaps},
+	{ID: "distil-whisper-large-v3-en", Provider: ProviderGroq, Type: ModelTypeSpeechToText, Capabilities: audioCaps},
+	// Cohere
+	{ID: "command-r-plus", Provider: ProviderCohere, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 128000, Pricing: &Pricing{Input: 2.50, Output: 10.00}},
+	{ID: "command-r", Provider: ProviderCohere, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 128000, Pricing: &Pricing{Input: 0.15, Output: 0.60}},
+	{ID: "command-r7b-12-2024", Provider: ProviderCohere, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 128000, Pricing: &Pricing{Input: 0.0375, Output: 0.15}},
+	{ID: "command", Provider: ProviderCohere, Type: ModelTypeChat, Capabilities: []Capability{CapabilityText}, ContextWindow: 4096, Pricing: &Pricing{Input: 1.00, Output: 2.00}},
+	{ID: "command-light", Provider: ProviderCohere, Type: ModelTypeChat, Capabilities: []Capability{CapabilityText}, ContextWindow: 4096, Pricing: &Pricing{Input: 0.30, Output: 0.60}},
+	// Anthropic
+	{ID: "claude-3-5-sonnet-latest", Provider: ProviderAnthropic, Type: ModelTypeChat, Capabilities: chatVisionCaps, ContextWindow: 200000, Pricing: &Pricing{Input: 3.00, Output: 15.00}},
+	{ID: "claude-3-5-haiku-latest", Provider: ProviderAnthropic, Type: ModelTypeChat, Capabilities: chatCaps, ContextWindow: 200000, Pricing: &Pricing{Input: 0.80, Output: 4.00}},
+	{ID: "claude-3-opus-latest", Provider: ProviderAnthropic, Type: ModelTypeChat, Capabilities: chatVisionCaps, ContextWindow: 200000, Pricing: &Pricing{Input: 15.00, Output: 75.00}},
+	// OpenAI, served by openai-compatible provider
+	{ID: "gpt-4o", Provider: ProviderOpenAICompatible, Type: ModelTypeChat, Capabilities: chatVisionCaps, ContextWindow: 128000, Pricing: &Pricing{Input: 2.50, Output: 10.00}},
+	{ID: "gpt-4o-mini", Provider: ProviderOpenAICompatible, Type: ModelTypeChat, Capabilities: chatVisionCaps, ContextWindow: 128000, Pricing: &Pricing{Input: 0.15, Output: 0.60}},
+}
+
+// LookupModel returns model info from user overrides in config,
+// built-in registry or guessed from the model name, in that order.
+func (c *Config) LookupModel(model string) (*ModelInfo, error) {
+	for _, info := range c.Models {
+		if info.ID == model {
+			return info, nil
+		}
+	}
+	for _, info := range knownModels {
+		if info.ID == model {
+			return info, nil
+		}
+	}
+	if info := guessModel(model); info != nil {
+		return info, nil
+	}
+	return nil, fmt.Errorf("unknown model: %s, describe it in models section of config", model)
+}
+
+// guessModel infers model info from well known model family names.
+func guessModel(model string) *ModelInfo {
+	// Ollama model tags look like qwen2.5-coder:7b, match on the name only
+	name, tag, tagged := strings.Cut(model, ":")
 	switch {
-	case strings.Contains(model, "command"):
-		return ModelTypeChat, nil
-	case strings.Contains(model, "gemma"):
-		return ModelTypeChat, nil
-	case strings.Contains(model, "llama"):
-		return ModelTypeChat, nil
-	case strings.Contains(model, "llava"):
-		return ModelTypeChatImage, nil
-	case strings.Contains(model, "whisper"):
-		return ModelTypeSpeechToText, nil
+	case strings.Contains(name, "whisper"):
+		return &ModelInfo{ID: model, Type: ModelTypeSpeechToText, Capabilities: audioCaps}
+	case strings.Contains(name, "llava"),
+		strings.Contains(name, "vision"),
+		strings.Contains(name, "moondream"):
+		return &ModelInfo{ID: model, Type: ModelTypeChatImage, Capabilities: []Capability{CapabilityText, CapabilityVision}}
+	case strings.Contains(name, "gpt-4o"),
+		strings.Contains(name, "claude"):
+		return &ModelInfo{ID: model, Type: ModelTypeChat, Capabilities: chatVisionCaps}
+	case strings.Contains(name, "command"),
+		strings.Contains(name, "gemma"),
+		strings.Contains(name, "llama"),
+		strings.Contains(name, "qwen"),
+		strings.Contains(name, "mistral"),
+		strings.Contains(name, "mixtral"),
+		strings.Contains(name, "phi"),
+		strings.Contains(name, "deepseek"):
+		return &ModelInfo{ID: model, Type: ModelTypeChat, Capabilities: []Capability{CapabilityText}}
+	case tagged && tag != "":
+		// unknown local model, most of them are chat models
+		return &ModelInfo{ID: model, Type: ModelTypeChat, Capabilities: []Capability{CapabilityText}}
 	default:
-		return "", fmt.Errorf("unknown model: %s", model)
+		return nil
 	}
 }
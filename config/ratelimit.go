@@ -0,0 +1,14 @@
+package config
+
+const rateLimitStatePath = ".cmd/ratelimit.json"
+
+// RateLimit is a client side limit, zero means unlimited.
+type RateLimit struct {
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+	TokensPerMinute   int `json:"tokens_per_minute,omitempty"`
+}
+
+// RateLimitStatePath is where rate limiters of concurrent invocations keep shared state.
+func RateLimitStatePath() (string, error) {
+	return homePath(rateLimitStatePath)
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+)
+
+// Runner describes how code blocks of a language are run. In Build, Command and Env
+// {file} is replaced with path of the written file, {dir} with working directory
+// and {code} with the code itself.
+type Runner struct {
+	// Lang is the tag of fenced code blocks, e.g. "python"
+	Lang string `json:"lang"`
+	// Aliases are other tags of the language, e.g. "py"
+	Aliases []string `json:"aliases,omitempty"`
+	// File is the name code is written to in working directory, code isn't written when empty
+	File string `json:"file,omitempty"`
+	// Build compiles the code before Command is run
+	Build []string `json:"build,omitempty"`
+	// Command runs the code, when empty code is only written, e.g. to be used by other blocks
+	Command []string `json:"command,omitempty"`
+	// Env is added to environment of Build and Command
+	Env map[string]string `json:"env,omitempty"`
+}
+
+func (r *Runner) matches(tag string) bool {
+	return r.Lang == tag || slices.Contains(r.Aliases, tag)
+}
+
+// knownRunners is the built-in registry, entries can be overridden with Config.Runners.
+var knownRunners = []*Runner{
+	{Lang: "go", File: "main.go", Command: []string{"go", "run", "{file}"}},
+	{Lang: "bash", Command: []string{"bash", "-c", "{code}"}},
+	{Lang: "html", File: "index.html", Command: append(openCommand(), "file://{file}")},
+	// assumed to be part of html, so not executed separately
+	// TODO can file names be parsed from model output? sometimes changes
+	{Lang: "javascript", Aliases: []string{"js"}, File: "script.js"},
+	{Lang: "css", File: "style.css"},
+	{Lang: "python", Aliases: []string{"python3", "py"}, File: "main.py", Command: []string{"python", "{file}"}},
+}
+
+// openCommand opens file or URL in default application of the OS.
+func openCommand() []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"open"}
+	case "windows":
+		return []string{"rundll32", "url.dll,FileProtocolHandler"}
+	default:
+		return []string{"xdg-open"}
+	}
+}
+
+// LookupRunner returns runner of code blocks tagged with tag from user overrides
+// in config or built-in registry, in that order. Overrides of built-in runners
+// apply to their aliases too.
+func (c *Config) LookupRunner(tag string) (*Runner, error) {
+	for _, runner := range c.Runners {
+		if runner.matches(tag) {
+			return runner, nil
+		}
+	}
+	for _, known := range knownRunners {
+		if !known.matches(tag) {
+			continue
+		}
+		for _, runner := range c.Runners {
+			if runner.Lang == known.Lang {
+				return runner, nil
+			}
+		}
+		return known, nil
+	}
+	return nil, fmt.Errorf("no runner for %q code blocks, describe it in runners section of config", tag)
+}
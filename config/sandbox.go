@@ -0,0 +1,28 @@
+package config
+
+// SandboxDefault is the key of sandbox settings for languages without their own.
+const SandboxDefault = "default"
+
+// SandboxConfig restricts generated code to read only file system except its
+// scratch directory, without network access unless allowed. Zero limits are defaults.
+type SandboxConfig struct {
+	Network bool `json:"network,omitempty"`
+	// Writable are paths code can write to in addition to its scratch directory, ~ is home directory
+	Writable []string `json:"writable,omitempty"`
+	// Env are names of environment variables passed to code in addition to the basic
+	// ones like PATH and HOME, the rest of environment is left out
+	Env     []string `json:"env,omitempty"`
+	Timeout Duration `json:"timeout,omitempty"`
+	// MemoryMB limits data segment of each process
+	MemoryMB int `json:"memory_mb,omitempty"`
+	// CPUSeconds limits CPU time of each process
+	CPUSeconds int `json:"cpu_seconds,omitempty"`
+}
+
+// SandboxFor returns sandbox settings of the language, nil if code runs unrestricted.
+func (c *Config) SandboxFor(lang string) *SandboxConfig {
+	if sb, ok := c.Sandbox[lang]; ok {
+		return sb
+	}
+	return c.Sandbox[SandboxDefault]
+}
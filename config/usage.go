@@ -0,0 +1,82 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const usagePath = ".cmd/usage.jsonl"
+
+// UsageEntry is a record of a single request in the usage ledger.
+type UsageEntry struct {
+	Time             time.Time `json:"time"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	// Cost in USD, zero when model pricing is unknown
+	Cost float64 `json:"cost,omitempty"`
+}
+
+// Cost returns price of the tokens in USD, zero if pricing is unknown.
+func (c *Config) Cost(model string, promptTokens, completionTokens int) float64 {
+	info, err := c.LookupModel(model)
+	if err != nil || info.Pricing == nil {
+		return 0
+	}
+	return (float64(promptTokens)*info.Pricing.Input + float64(completionTokens)*info.Pricing.Output) / 1e6
+}
+
+// AppendUsage adds entry to the ledger, each entry is a single line
+// so concurrent invocations don't corrupt it.
+func AppendUsage(entry *UsageEntry) error {
+	path, err := homePath(usagePath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), fs.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+func ReadUsage() ([]*UsageEntry, error) {
+	path, err := homePath(usagePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var entries []*UsageEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := &UsageEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			// skip lines torn by a crash
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
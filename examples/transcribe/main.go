@@ -8,8 +8,6 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"sync"
 	"time"
 
@@ -17,7 +15,11 @@ import (
 	"github.com/daulet/cmd/provider"
 )
 
-const MAX_CONCURRENT_REQUESTS = 2
+const (
+	MAX_CONCURRENT_REQUESTS = 4
+	// Groq free tier limit for whisper models
+	REQUESTS_PER_MINUTE = 20
+)
 
 type work struct {
 	idx  int
@@ -44,9 +46,23 @@ func run() error {
 	if err != nil {
 		return err
 	}
+	statePath, err := config.RateLimitStatePath()
+	if err != nil {
+		return err
+	}
+	// limiter paces concurrent requests, including other running instances
+	prov = provider.NewRateLimitProvider(prov, config.ProviderGroq, map[string]*config.RateLimit{
+		config.ProviderGroq: {RequestsPerMinute: REQUESTS_PER_MINUTE},
+	}, statePath)
+	// audio seconds limits are still hit, so wait for as long as Groq asks
+	prov = provider.NewRetryProvider(prov, &config.RetryConfig{
+		MaxAttempts: 10,
+		MaxBackoff:  config.Duration(10 * time.Minute),
+		MaxElapsed:  config.Duration(time.Hour),
+	})
 
 	{
-		cache, err := provider.NewCacheProvider(prov, ".cache/cache.json")
+		cache, err := provider.NewCacheProvider(prov, ".cache/cache.json", false)
 		if err != nil {
 			return fmt.Errorf("failed to create cache provider: %w", err)
 		}
@@ -78,32 +94,15 @@ func run() error {
 				if err != nil {
 					panic(err)
 				}
-				for {
-					fmt.Println("transcribing", item.file)
-					segments, err := prov.Transcribe(ctx, &config.Config{}, &provider.AudioFile{
-						FilePath: item.file,
-						Reader:   bytes.NewReader(data),
-					})
-					if err != nil {
-						waitTime := time.Minute
-						// Parse error message like:
-						// "Please try again in 6m6.125s."
-						re := regexp.MustCompile(`Please try again in (\d+)m(\d+.\d+)s\.`)
-						matches := re.FindStringSubmatch(err.Error())
-						if len(matches) == 3 {
-							minutes, _ := strconv.Atoi(matches[1])
-							seconds, _ := strconv.ParseFloat(matches[2], 64)
-							waitTime = time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
-						} else {
-							fmt.Printf("failed to parse error: %w\n", err)
-						}
-						fmt.Printf("waiting for %s\n", waitTime)
-						<-time.After(waitTime)
-						continue
-					}
-					resCh <- &result{idx: item.idx, segments: segments}
-					break
+				fmt.Println("transcribing", item.file)
+				segments, err := prov.Transcribe(ctx, &config.Config{}, &provider.AudioFile{
+					FilePath: item.file,
+					Reader:   bytes.NewReader(data),
+				})
+				if err != nil {
+					panic(err)
 				}
+				resCh <- &result{idx: item.idx, segments: segments}
 			}
 		}()
 	}
@@ -40,6 +40,8 @@ func language(s string) Language {
 
 type CodeBlock struct {
 	Lang Language
+	// Tag is the language identifier following backticks in lower case, e.g. "rust"
+	Tag  string
 	Code string
 }
 
@@ -91,7 +93,11 @@ func scanBlocks(r io.Reader, blocks chan<- *CodeBlock) {
 	for buf.Scan() {
 		line := buf.Text()
 		if strings.HasPrefix(line, "```") {
-			lang := language(strings.TrimPrefix(line, "```"))
+			var tag string
+			// info string can have attributes after the language, e.g. ```python title="main.py"
+			if fields := strings.Fields(strings.TrimPrefix(line, "```")); len(fields) > 0 {
+				tag = strings.ToLower(fields[0])
+			}
 			var block bytes.Buffer
 			for buf.Scan() {
 				line = buf.Text()
@@ -102,7 +108,8 @@ func scanBlocks(r io.Reader, blocks chan<- *CodeBlock) {
 				block.WriteString("\n")
 			}
 			blocks <- &CodeBlock{
-				Lang: lang,
+				Lang: language(tag),
+				Tag:  tag,
 				Code: block.String(),
 			}
 		}
@@ -0,0 +1,269 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var fencedJSON = regexp.MustCompile("(?s)```(?:json)?\\s*\\n(.*?)\\n```")
+
+// ExtractJSON strips code fences and prose models tend to wrap JSON with. Prose can
+// have brackets too, e.g. "see [1]", so the largest valid JSON object or array is taken.
+func ExtractJSON(s string) string {
+	if m := fencedJSON.FindStringSubmatch(s); m != nil {
+		s = m[1]
+	}
+	s = strings.TrimSpace(s)
+	var best string
+	for i := 0; i < len(s); i++ {
+		if s[i] != '{' && s[i] != '[' {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(s[i:]))
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			continue
+		}
+		end := i + int(dec.InputOffset())
+		if end-i > len(best) {
+			best = s[i:end]
+		}
+		// values nested in this one are smaller
+		i = end - 1
+	}
+	if best == "" {
+		// left as is, so validation explains what's wrong
+		return s
+	}
+	return best
+}
+
+// ValidateJSON checks data against JSON schema, supporting commonly used subset:
+// type, enum, const, properties, required, additionalProperties, items,
+// anyOf, oneOf, allOf and numeric, string and array bounds.
+func ValidateJSON(schema, data []byte) error {
+	var s any
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("invalid JSON: unexpected data after top-level value")
+	}
+	return validate(s, v, "$")
+}
+
+func validate(schema, v any, path string) error {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		// boolean schema
+		if b, ok := schema.(bool); ok && !b {
+			return fmt.Errorf("%s: not allowed", path)
+		}
+		return nil
+	}
+
+	if t, ok := s["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []any:
+			for _, tt := range t {
+				if ts, ok := tt.(string); ok {
+					types = append(types, ts)
+				}
+			}
+		}
+		if !slices.ContainsFunc(types, func(t string) bool { return isType(v, t) }) {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), typeOf(v))
+		}
+	}
+	if enum, ok := s["enum"].([]any); ok {
+		if !slices.ContainsFunc(enum, func(e any) bool { return equalJSON(e, v) }) {
+			return fmt.Errorf("%s: value is not one of %v", path, enum)
+		}
+	}
+	if c, ok := s["const"]; ok && !equalJSON(c, v) {
+		return fmt.Errorf("%s: expected %v", path, c)
+	}
+
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, ok := s[key].([]any)
+		if !ok {
+			continue
+		}
+		valid := 0
+		var firstErr error
+		for _, sub := range subs {
+			if err := validate(sub, v, path); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			valid++
+		}
+		switch {
+		case key == "allOf" && valid != len(subs):
+			return firstErr
+		case key == "anyOf" && valid == 0:
+			return fmt.Errorf("%s: doesn't match any schema of anyOf: %w", path, firstErr)
+		case key == "oneOf" && valid != 1:
+			return fmt.Errorf("%s: matches %d schemas of oneOf, expected exactly one", path, valid)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		return validateObject(s, v, path)
+	case []any:
+		if min, ok := number(s["minItems"]); ok && float64(len(v)) < min {
+			return fmt.Errorf("%s: expected at least %v items", path, min)
+		}
+		if max, ok := number(s["maxItems"]); ok && float64(len(v)) > max {
+			return fmt.Errorf("%s: expected at most %v items", path, max)
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				if err := validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if min, ok := number(s["minLength"]); ok && length < min {
+			return fmt.Errorf("%s: expected at least %v characters", path, min)
+		}
+		if max, ok := number(s["maxLength"]); ok && length > max {
+			return fmt.Errorf("%s: expected at most %v characters", path, max)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid schema pattern %q: %w", pattern, err)
+			}
+			if !re.MatchString(v) {
+				return fmt.Errorf("%s: %q doesn't match %s", path, v, pattern)
+			}
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if min, ok := number(s["minimum"]); ok && f < min {
+			return fmt.Errorf("%s: expected at least %v", path, min)
+		}
+		if max, ok := number(s["maximum"]); ok && f > max {
+			return fmt.Errorf("%s: expected at most %v", path, max)
+		}
+	}
+	return nil
+}
+
+func validateObject(s map[string]any, v map[string]any, path string) error {
+	if required, ok := s["required"].([]any); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+	}
+	props, _ := s["properties"].(map[string]any)
+	// sorted for deterministic errors
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		propPath := fmt.Sprintf("%s.%s", path, key)
+		if prop, ok := props[key]; ok {
+			if err := validate(prop, v[key], propPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if additional, ok := s["additionalProperties"]; ok {
+			if b, ok := additional.(bool); ok && !b {
+				return fmt.Errorf("%s: property is not allowed", propPath)
+			}
+			if err := validate(additional, v[key], propPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isType(v any, t string) bool {
+	switch t {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	default:
+		return typeOf(v) == t
+	}
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// number reads numeric schema keyword, schema is decoded without UseNumber.
+func number(v any) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+// equalJSON compares schema value with decoded data, numbers are compared by value.
+func equalJSON(schemaValue, v any) bool {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		sf, ok := schemaValue.(float64)
+		return err == nil && ok && f == sf
+	}
+	a, err := json.Marshal(schemaValue)
+	if err != nil {
+		return false
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(a, b)
+}
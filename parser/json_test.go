@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain object", in: `{"a": 1}`, want: `{"a": 1}`},
+		{name: "plain array", in: ` [1, 2] `, want: `[1, 2]`},
+		{name: "fenced", in: "Here you go:\n```json\n{\"a\": 1}\n```\nEnjoy!", want: `{"a": 1}`},
+		{name: "fenced without language", in: "```\n[\"x\"]\n```", want: `["x"]`},
+		{name: "prose around", in: `The answer is {"a": {"b": [1]}} as requested.`, want: `{"a": {"b": [1]}}`},
+		{name: "brackets in prose before", in: `As noted in [1], the result is {"a": 1}.`, want: `{"a": 1}`},
+		{name: "braces in prose after", in: `{"a": 1} is valid, {b} is not`, want: `{"a": 1}`},
+		{name: "unbalanced prose bracket", in: `Result [see below: {"ok": true}`, want: `{"ok": true}`},
+		{name: "braces inside strings", in: `{"a": "}{]["}`, want: `{"a": "}{]["}`},
+		{name: "no JSON", in: ` not json `, want: `not json`},
+		{name: "broken JSON", in: `{"a": }`, want: `{"a": }`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractJSON(tt.in); got != tt.want {
+				t.Errorf("ExtractJSON(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateJSON(t *testing.T) {
+	const person = `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "items": {"enum": ["a", "b"]}, "maxItems": 2}
+		},
+		"required": ["name"],
+		"additionalProperties": false
+	}`
+	tests := []struct {
+		name   string
+		schema string
+		data   string
+		ok     bool
+	}{
+		{name: "valid", schema: person, data: `{"name": "x", "age": 3, "tags": ["a"]}`, ok: true},
+		{name: "missing required", schema: person, data: `{"age": 3}`},
+		{name: "wrong type", schema: person, data: `{"name": 1}`},
+		{name: "not integer", schema: person, data: `{"name": "x", "age": 1.5}`},
+		{name: "integer as float", schema: person, data: `{"name": "x", "age": 2.0}`, ok: true},
+		{name: "below minimum", schema: person, data: `{"name": "x", "age": -1}`},
+		{name: "empty string", schema: person, data: `{"name": ""}`},
+		{name: "not in enum", schema: person, data: `{"name": "x", "tags": ["c"]}`},
+		{name: "too many items", schema: person, data: `{"name": "x", "tags": ["a", "b", "a"]}`},
+		{name: "additional property", schema: person, data: `{"name": "x", "extra": true}`},
+		{name: "invalid JSON", schema: person, data: `{"name": }`},
+		{name: "trailing data", schema: person, data: `{"name": "x"} {}`},
+		{name: "type list", schema: `{"type": ["string", "null"]}`, data: `null`, ok: true},
+		{name: "const", schema: `{"const": 5}`, data: `5.0`, ok: true},
+		{name: "const mismatch", schema: `{"const": "a"}`, data: `"b"`},
+		{name: "anyOf", schema: `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, data: `1`, ok: true},
+		{name: "anyOf mismatch", schema: `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, data: `true`},
+		{name: "oneOf matches both", schema: `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, data: `1`},
+		{name: "allOf", schema: `{"allOf": [{"minimum": 1}, {"maximum": 3}]}`, data: `4`},
+		{name: "pattern", schema: `{"pattern": "^[a-z]+$"}`, data: `"abc"`, ok: true},
+		{name: "pattern mismatch", schema: `{"pattern": "^[a-z]+$"}`, data: `"ABC"`},
+		{name: "false schema", schema: `{"properties": {"a": false}}`, data: `{"a": 1}`},
+		{name: "invalid schema", schema: `{`, data: `1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateJSON([]byte(tt.schema), []byte(tt.data))
+			if tt.ok && err != nil {
+				t.Errorf("ValidateJSON(%s) = %v, want nil", tt.data, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("ValidateJSON(%s) = nil, want error", tt.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/daulet/cmd/config"
+)
+
+const (
+	ANTHROPIC_API_KEY = "ANTHROPIC_API_KEY"
+
+	ANTHROPIC_BASE_URL = "https://api.anthropic.com/v1"
+	ANTHROPIC_VERSION  = "2023-06-01"
+
+	DEFAULT_ANTHROPIC_CHAT_MODEL = "claude-3-5-sonnet-latest"
+	// max_tokens is required by Messages API
+	DEFAULT_ANTHROPIC_MAX_TOKENS = 4096
+)
+
+func NewAnthropicProvider() (Provider, error) {
+	apiKey := os.Getenv(ANTHROPIC_API_KEY)
+	if apiKey == "" {
+		return nil, fmt.Errorf("Set %s env variable to your Anthropic API key. Get one at https://console.anthropic.com/settings/keys", ANTHROPIC_API_KEY)
+	}
+	return &anthropicProvider{
+		client:  http.DefaultClient,
+		baseURL: ANTHROPIC_BASE_URL,
+		apiKey:  apiKey,
+	}, nil
+}
+
+var _ Provider = (*anthropicProvider)(nil)
+
+type anthropicProvider struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+}
+
+type anthropicRequest struct {
+	Model         string              `json:"model"`
+	System        string              `json:"system,omitempty"`
+	Messages      []*anthropicMessage `json:"messages"`
+	MaxTokens     int                 `json:"max_tokens"`
+	Temperature   *float64            `json:"temperature,omitempty"`
+	TopP          *float64            `json:"top_p,omitempty"`
+	TopK          *int                `json:"top_k,omitempty"`
+	StopSequences []string            `json:"stop_sequences,omitempty"`
+	Stream        bool                `json:"stream"`
+}
+
+type anthropicMessage struct {
+	Role    string              `json:"role"`
+	Content []*anthropicContent `json:"content"`
+}
+
+type anthropicContent struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *anthropicImageSource `json:"source,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+func (p *anthropicProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	start := time.Now()
+	if len(newStreamOptions(opts).tools) > 0 {
+		return nil, fmt.Errorf("tools are not supported by Anthropic provider")
+	}
+	var (
+		system   []string
+		messages []*anthropicMessage
+		hasImage bool
+	)
+	for _, msg := range msgs {
+		switch msg.Role {
+		case System:
+			system = append(system, msg.Content)
+		case User:
+			message := &anthropicMessage{Role: "user"}
+			if msg.Content != "" {
+				message.Content = append(message.Content, &anthropicContent{Type: "text", Text: msg.Content})
+			}
+			for _, part := range msg.MultiPart {
+				switch field := part.Field.(type) {
+				case *ImagePart:
+					mediaType, data, err := parseDataURL(field.Data)
+					if err != nil {
+						return nil, err
+					}
+					message.Content = append(message.Content, &anthropicContent{
+						Type: "image",
+						Source: &anthropicImageSource{
+							Type:      "base64",
+							MediaType: mediaType,
+							Data:      data,
+						},
+					})
+					hasImage = true
+				case *TextPart:
+					// API rejects empty text blocks, e.g. image sent without a prompt
+					if field.Text != "" {
+						message.Content = append(message.Content, &anthropicContent{Type: "text", Text: field.Text})
+					}
+				default:
+					panic("unknown part type")
+				}
+			}
+			messages = append(messages, message)
+		case Assistant:
+			// API rejects empty text blocks, e.g. of a turn that only called tools
+			if msg.Content == "" {
+				continue
+			}
+			messages = append(messages, &anthropicMessage{
+				Role:    "assistant",
+				Content: []*anthropicContent{{Type: "text", Text: msg.Content}},
+			})
+		default:
+			return nil, fmt.Errorf("unknown role: %s", msg.Role)
+		}
+	}
+
+	// models of other providers are left for them, e.g. ones configured for primary provider
+	model := DEFAULT_ANTHROPIC_CHAT_MODEL
+	if chat := cfg.Model[config.ModelTypeChat]; chat != "" && !otherProviderModel(cfg, chat) {
+		model = chat
+	}
+	if image := cfg.Model[config.ModelTypeChatImage]; hasImage && image != "" && !otherProviderModel(cfg, image) {
+		model = image
+	}
+	if cfg.FrequencyPenalty != nil || cfg.PresencePenalty != nil || cfg.Seed != nil {
+		fmt.Fprintf(os.Stderr, "warning: frequency_penalty, presence_penalty and seed are not supported by Anthropic API, ignoring them\n")
+	}
+	req := &anthropicRequest{
+		Model:         model,
+		System:        strings.Join(system, "\n\n"),
+		Messages:      messages,
+		MaxTokens:     DEFAULT_ANTHROPIC_MAX_TOKENS,
+		Temperature:   cfg.Temperature,
+		TopP:          cfg.TopP,
+		TopK:          cfg.TopK,
+		StopSequences: cfg.Stop,
+		Stream:        true,
+	}
+	if cfg.MaxTokens != nil {
+		req.MaxTokens = *cfg.MaxTokens
+	}
+
+	resp, err := p.do(ctx, http.MethodPost, "/messages", req)
+	if err != nil {
+		return nil, err
+	}
+	return &anthropicStreamReader{
+		usageTracker: newUsageTracker(model, start),
+		body:         resp.Body,
+		scanner:      bufio.NewScanner(resp.Body),
+	}, nil
+}
+
+func (p *anthropicProvider) Transcribe(ctx context.Context, cfg *config.Config, audio *AudioFile) ([]*AudioSegment, error) {
+	return nil, fmt.Errorf("transcription is not supported by Anthropic")
+}
+
+func (p *anthropicProvider) ListModels(ctx context.Context) ([]*Model, error) {
+	resp, err := p.do(ctx, http.MethodGet, "/models?limit=1000", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var models struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return nil, err
+	}
+	var res []*Model
+	for _, model := range models.Data {
+		res = append(res, &Model{
+			ID:     model.ID,
+			Owner:  "anthropic",
+			Active: true,
+		})
+	}
+	return res, nil
+}
+
+func (p *anthropicProvider) ListConnectors(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (p *anthropicProvider) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("x-api-key", p.apiKey)
+	header.Set("anthropic-version", ANTHROPIC_VERSION)
+	return doJSON(ctx, p.client, method, p.baseURL+path, header, body)
+}
+
+// parseDataURL splits "data:image/png;base64,..." into media type and base64 data.
+func parseDataURL(url string) (string, string, error) {
+	header, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	mediaType, isBase64 := strings.CutSuffix(header, ";base64")
+	if !ok || !isBase64 {
+		return "", "", fmt.Errorf("image is expected to be base64 data URL")
+	}
+	return mediaType, data, nil
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message *struct {
+		Usage *anthropicUsage `json:"usage"`
+	} `json:"message,omitempty"`
+	Usage *anthropicUsage `json:"usage,omitempty"`
+	Delta *struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+var (
+	_ io.ReadCloser = (*anthropicStreamReader)(nil)
+	_ UsageReporter = (*anthropicStreamReader)(nil)
+)
+
+// anthropicStreamReader reads text deltas from server-sent events.
+type anthropicStreamReader struct {
+	usageTracker
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	buf     []byte
+}
+
+func (r *anthropicStreamReader) Close() error {
+	return r.body.Close()
+}
+
+func (r *anthropicStreamReader) Read(p []byte) (int, error) {
+	if len(r.buf) > 0 {
+		n := copy(p, r.buf)
+		if n < len(r.buf) {
+			r.buf = r.buf[n:]
+			return n, nil
+		}
+		r.buf = nil
+		return n, nil
+	}
+	for r.scanner.Scan() {
+		data, ok := strings.CutPrefix(r.scanner.Text(), "data: ")
+		if !ok {
+			// event names are repeated in data, skip them as well as empty lines
+			continue
+		}
+		var event anthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return 0, err
+		}
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil && event.Message.Usage != nil {
+				r.usage.PromptTokens = event.Message.Usage.InputTokens
+				r.usage.CompletionTokens = event.Message.Usage.OutputTokens
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				// output tokens are cumulative
+				r.usage.CompletionTokens = event.Usage.OutputTokens
+			}
+		case "content_block_delta":
+			if event.Delta == nil || event.Delta.Type != "text_delta" {
+				continue
+			}
+			out := []byte(event.Delta.Text)
+			r.generated(len(out))
+			n := copy(p, out)
+			if n < len(out) {
+				r.buf = out[n:]
+			}
+			return n, nil
+		case "message_stop":
+			r.body.Close()
+			return 0, io.EOF
+		case "error":
+			r.body.Close()
+			if event.Error != nil {
+				return 0, fmt.Errorf("%s: %s", event.Error.Type, event.Error.Message)
+			}
+			return 0, fmt.Errorf("unknown stream error")
+		}
+	}
+	r.body.Close()
+	if err := r.scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, io.EOF
+}
+
+// otherProviderModel reports whether registry knows model as served by another provider,
+// e.g. chat-image model left in config from using groq.
+func otherProviderModel(cfg *config.Config, model string) bool {
+	info, err := cfg.LookupModel(model)
+	return err == nil && info.Provider != "" && info.Provider != config.ProviderAnthropic
+}
@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/daulet/cmd/config"
+)
+
+// anthropicRequestOf returns request anthropic provider sends for msgs.
+func anthropicRequestOf(t *testing.T, cfg *config.Config, msgs []*Message) *anthropicRequest {
+	t.Helper()
+	var req anthropicRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		http.Error(w, "recorded", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	p := &anthropicProvider{client: srv.Client(), baseURL: srv.URL, apiKey: "test"}
+	if _, err := p.Stream(context.Background(), cfg, msgs); err == nil {
+		t.Fatal("request didn't reach the server")
+	}
+	return &req
+}
+
+func TestAnthropicMessages(t *testing.T) {
+	cfg := &config.Config{Provider: config.ProviderAnthropic}
+	msgs := []*Message{
+		{Role: User, Content: "hi"},
+		{Role: Assistant},
+		{Role: User, MultiPart: []*MessagePart{
+			{Field: &TextPart{}},
+			{Field: &ImagePart{Data: "data:image/png;base64,AAAA"}},
+		}},
+	}
+	req := anthropicRequestOf(t, cfg, msgs)
+	for _, msg := range req.Messages {
+		for _, content := range msg.Content {
+			if content.Type == "text" && content.Text == "" {
+				t.Errorf("empty text block in %s message", msg.Role)
+			}
+		}
+		if len(msg.Content) == 0 {
+			t.Errorf("%s message without content", msg.Role)
+		}
+	}
+	if len(req.Messages) != 2 {
+		t.Errorf("%d messages, want 2 without the empty assistant one", len(req.Messages))
+	}
+}
+
+func TestAnthropicModel(t *testing.T) {
+	text := []*Message{{Role: User, Content: "hi"}}
+	image := []*Message{{Role: User, MultiPart: []*MessagePart{
+		{Field: &TextPart{Text: "what is it?"}},
+		{Field: &ImagePart{Data: "data:image/png;base64,AAAA"}},
+	}}}
+	tests := []struct {
+		name  string
+		model map[string]string
+		msgs  []*Message
+		want  string
+	}{
+		{name: "default", msgs: text, want: DEFAULT_ANTHROPIC_CHAT_MODEL},
+		{name: "configured", model: map[string]string{config.ModelTypeChat: "claude-3-5-haiku-latest"}, msgs: text, want: "claude-3-5-haiku-latest"},
+		{name: "unknown model", model: map[string]string{config.ModelTypeChat: "claude-next"}, msgs: text, want: "claude-next"},
+		{name: "chat model of other provider", model: map[string]string{config.ModelTypeChat: "llama-3.1-8b-instant"}, msgs: text, want: DEFAULT_ANTHROPIC_CHAT_MODEL},
+		{name: "image model", model: map[string]string{config.ModelTypeChatImage: "claude-3-5-sonnet-latest"}, msgs: image, want: "claude-3-5-sonnet-latest"},
+		{name: "image model of other provider", model: map[string]string{config.ModelTypeChatImage: "llama-3.2-90b-vision-preview"}, msgs: image, want: DEFAULT_ANTHROPIC_CHAT_MODEL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Provider: config.ProviderAnthropic, Model: tt.model}
+			if got := anthropicRequestOf(t, cfg, tt.msgs).Model; got != tt.want {
+				t.Errorf("model = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
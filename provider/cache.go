@@ -7,56 +7,152 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/daulet/cmd/config"
 )
 
+// ErrCacheMiss is returned in replay mode when response is not cached.
+var ErrCacheMiss = errors.New("cache miss")
+
 type ProviderCloser interface {
 	Provider
 	io.Closer
 }
 
-func NewCacheProvider(p Provider, cachePath string) (ProviderCloser, error) {
+// NewCacheProvider caches transcriptions in cachePath, and chat responses
+// when chats is set, which makes the same request replay the same answer.
+func NewCacheProvider(p Provider, cachePath string, chats bool) (ProviderCloser, error) {
+	c, err := readCache(cachePath)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheProvider{p: p, c: c, added: newCache(), cachePath: cachePath, chats: chats}, nil
+}
+
+// NewRefreshProvider never serves cached responses, but records new ones
+// in place of cached ones, e.g. to get another answer to the same prompt.
+func NewRefreshProvider(p Provider, cachePath string, chats bool) (ProviderCloser, error) {
+	return &cacheProvider{p: p, c: newCache(), added: newCache(), cachePath: cachePath, chats: chats}, nil
+}
+
+// NewReplayProvider only serves responses recorded in the cache,
+// it never calls a provider and returns ErrCacheMiss instead.
+func NewReplayProvider(cachePath string) (ProviderCloser, error) {
+	c, err := readCache(cachePath)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheProvider{c: c, cachePath: cachePath, replay: true}, nil
+}
+
+func newCache() *cache {
+	return &cache{
+		AudioSegments: make(map[string][]*AudioSegment),
+		Chats:         make(map[string][]string),
+		ToolCalls:     make(map[string][]*ToolCall),
+	}
+}
+
+func readCache(cachePath string) (*cache, error) {
+	c := newCache()
 	data, err := os.ReadFile(cachePath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return &cacheProvider{p: p, c: &cache{AudioSegments: make(map[string][]*AudioSegment)}, cachePath: cachePath}, nil
+			return c, nil
 		}
 		return nil, err
 	}
-	cache := &cache{
-		AudioSegments: make(map[string][]*AudioSegment),
+	if err := json.Unmarshal(data, c); err != nil {
+		// cache only saves requests, start over if it got corrupted
+		fmt.Fprintf(os.Stderr, "warning: ignoring unreadable cache %s: %v\n", cachePath, err)
+		return newCache(), nil
 	}
-	if err := json.Unmarshal(data, cache); err != nil {
-		return nil, err
+	if c.AudioSegments == nil {
+		c.AudioSegments = make(map[string][]*AudioSegment)
 	}
-	return &cacheProvider{p: p, c: cache, cachePath: cachePath}, nil
+	if c.Chats == nil {
+		c.Chats = make(map[string][]string)
+	}
+	if c.ToolCalls == nil {
+		c.ToolCalls = make(map[string][]*ToolCall)
+	}
+	return c, nil
 }
 
 var _ ProviderCloser = (*cacheProvider)(nil)
 
 type cacheProvider struct {
-	p         Provider
-	c         *cache
+	p Provider
+	c *cache
+	// added is what was recorded by this provider, merged into the cache file on Close
+	added     *cache
 	cachePath string
+	// replay mode never calls p
+	replay bool
+	// chats are cached, otherwise only transcriptions are
+	chats bool
+
+	mu sync.Mutex
 }
 
 // ListConnectors implements Provider.
 func (c *cacheProvider) ListConnectors(ctx context.Context) ([]string, error) {
+	if c.replay {
+		return nil, fmt.Errorf("%w: connectors are not cached", ErrCacheMiss)
+	}
 	return c.p.ListConnectors(ctx)
 }
 
 // ListModels implements Provider.
-func (c *cacheProvider) ListModels(ctx context.Context) ([]string, error) {
+func (c *cacheProvider) ListModels(ctx context.Context) ([]*Model, error) {
+	if c.replay {
+		return nil, fmt.Errorf("%w: models are not cached", ErrCacheMiss)
+	}
 	return c.p.ListModels(ctx)
 }
 
 // Stream implements Provider.
-func (c *cacheProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message) (io.Reader, error) {
-	return c.p.Stream(ctx, cfg, msgs)
+func (c *cacheProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	if !c.chats && !c.replay {
+		return c.p.Stream(ctx, cfg, msgs, opts...)
+	}
+	key, err := chatKey(cfg, msgs, newStreamOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	chunks, ok := c.c.Chats[key]
+	toolCalls := c.c.ToolCalls[key]
+	c.mu.Unlock()
+	if ok {
+		return &chunkReader{chunks: chunks, toolCalls: toolCalls}, nil
+	}
+	if c.replay {
+		return nil, fmt.Errorf("%w: chat %s", ErrCacheMiss, key)
+	}
+	r, err := c.p.Stream(ctx, cfg, msgs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &recordReader{
+		r: r,
+		done: func(chunks []string, toolCalls []*ToolCall) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			for _, cache := range []*cache{c.c, c.added} {
+				cache.Chats[key] = chunks
+				if len(toolCalls) > 0 {
+					cache.ToolCalls[key] = toolCalls
+				}
+			}
+		},
+	}, nil
 }
 
 // Transcribe implements Provider.
@@ -66,30 +162,238 @@ func (c *cacheProvider) Transcribe(ctx context.Context, cfg *config.Config, audi
 		return nil, err
 	}
 	audio.Reader = bytes.NewReader(data)
-	hash := sha256.Sum256(data)
-	key := base64.URLEncoding.EncodeToString(hash[:])
-	if c.c.AudioSegments[key] != nil {
-		return c.c.AudioSegments[key], nil
+	key := hashKey(data)
+	c.mu.Lock()
+	segments := c.c.AudioSegments[key]
+	c.mu.Unlock()
+	if segments != nil {
+		return segments, nil
+	}
+	if c.replay {
+		return nil, fmt.Errorf("%w: audio %s", ErrCacheMiss, key)
 	}
 	res, err := c.p.Transcribe(ctx, cfg, audio)
 	if err != nil {
 		return nil, err
 	}
+	c.mu.Lock()
 	c.c.AudioSegments[key] = res
+	c.added.AudioSegments[key] = res
+	c.mu.Unlock()
 	return res, nil
 }
 
 func (c *cacheProvider) Close() error {
+	if c.replay {
+		// nothing new is recorded
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.added.AudioSegments) == 0 && len(c.added.Chats) == 0 {
+		return nil
+	}
 	if err := os.MkdirAll(filepath.Dir(c.cachePath), 0755); err != nil {
 		return err
 	}
-	data, err := json.MarshalIndent(c.c, "", "  ")
+	// other invocations might have recorded to the same file since it was read
+	lock, err := os.OpenFile(c.cachePath+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	merged, err := readCache(c.cachePath)
+	if err != nil {
+		return err
+	}
+	maps.Copy(merged.AudioSegments, c.added.AudioSegments)
+	maps.Copy(merged.Chats, c.added.Chats)
+	maps.Copy(merged.ToolCalls, c.added.ToolCalls)
+	for key := range c.added.Chats {
+		if _, ok := c.added.ToolCalls[key]; !ok {
+			// refreshed response might not call tools anymore
+			delete(merged.ToolCalls, key)
+		}
+	}
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written next to the cache and renamed, so readers never see partial file
+	f, err := os.CreateTemp(filepath.Dir(c.cachePath), filepath.Base(c.cachePath)+".*")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(c.cachePath, data, 0644)
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.cachePath)
 }
 
 type cache struct {
 	AudioSegments map[string][]*AudioSegment `json:"audio_segments,omitempty"`
+	// Chats maps request key to streamed chunks of the response
+	Chats map[string][]string `json:"chats,omitempty"`
+	// ToolCalls maps request key to tool calls requested in the response
+	ToolCalls map[string][]*ToolCall `json:"tool_calls,omitempty"`
+}
+
+func hashKey(data []byte) string {
+	hash := sha256.Sum256(data)
+	return base64.URLEncoding.EncodeToString(hash[:])
+}
+
+// chatKey identifies a chat request by everything that affects the response.
+func chatKey(cfg *config.Config, msgs []*Message, opts *streamOptions) (string, error) {
+	type toolKey struct {
+		Name        string
+		Description string
+		Parameters  json.RawMessage
+	}
+	var tools []*toolKey
+	for _, tool := range opts.tools {
+		tools = append(tools, &toolKey{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		})
+	}
+	data, err := json.Marshal(struct {
+		Provider   string
+		Model      map[string]string
+		Connectors []string
+
+		Temperature      *float64
+		TopP             *float64
+		TopK             *int
+		FrequencyPenalty *float64
+		PresencePenalty  *float64
+		MaxTokens        *int
+		Stop             []string
+		Seed             *int
+
+		Messages   []*Message
+		Tools      []*toolKey      `json:",omitempty"`
+		JSON       bool            `json:",omitempty"`
+		JSONSchema json.RawMessage `json:",omitempty"`
+	}{
+		Provider:   cfg.Provider,
+		Model:      cfg.Model,
+		Connectors: cfg.Connectors,
+
+		Temperature:      cfg.Temperature,
+		TopP:             cfg.TopP,
+		TopK:             cfg.TopK,
+		FrequencyPenalty: cfg.FrequencyPenalty,
+		PresencePenalty:  cfg.PresencePenalty,
+		MaxTokens:        cfg.MaxTokens,
+		Stop:             cfg.Stop,
+		Seed:             cfg.Seed,
+
+		Messages:   msgs,
+		Tools:      tools,
+		JSON:       opts.json,
+		JSONSchema: opts.jsonSchema,
+	})
+	if err != nil {
+		return "", err
+	}
+	return hashKey(data), nil
+}
+
+var (
+	_ io.ReadCloser = (*recordReader)(nil)
+	_ ToolCaller    = (*recordReader)(nil)
+	_ UsageReporter = (*recordReader)(nil)
+)
+
+// recordReader records chunks read from the stream and reports them once stream is done.
+type recordReader struct {
+	r      io.Reader
+	chunks []string
+	done   func([]string, []*ToolCall)
+}
+
+func (r *recordReader) ToolCalls() []*ToolCall {
+	if caller, ok := r.r.(ToolCaller); ok {
+		return caller.ToolCalls()
+	}
+	return nil
+}
+
+func (r *recordReader) Usage() *Usage {
+	if reporter, ok := r.r.(UsageReporter); ok {
+		return reporter.Usage()
+	}
+	return nil
+}
+
+// Close abandons the stream, so partial response is not recorded.
+func (r *recordReader) Close() error {
+	r.done = nil
+	return closeReader(r.r)
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.chunks = append(r.chunks, string(p[:n]))
+	}
+	if err == io.EOF && r.done != nil {
+		r.done(r.chunks, r.ToolCalls())
+		r.done = nil
+	}
+	return n, err
+}
+
+var (
+	_ io.Reader  = (*chunkReader)(nil)
+	_ ToolCaller = (*chunkReader)(nil)
+)
+
+// chunkReader replays recorded chunks, one chunk per read.
+type chunkReader struct {
+	chunks    []string
+	toolCalls []*ToolCall
+	buf       []byte
+}
+
+func (r *chunkReader) ToolCalls() []*ToolCall {
+	return r.toolCalls
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.buf) > 0 {
+		n := copy(p, r.buf)
+		if n < len(r.buf) {
+			r.buf = r.buf[n:]
+			return n, nil
+		}
+		r.buf = nil
+		return n, nil
+	}
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	out := []byte(r.chunks[0])
+	r.chunks = r.chunks[1:]
+	n := copy(p, out)
+	if n < len(out) {
+		r.buf = out[n:]
+	}
+	return n, nil
 }
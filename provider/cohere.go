@@ -1,17 +1,23 @@
 package provider
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"net/http"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/daulet/cmd/config"
 
 	co "github.com/cohere-ai/cohere-go/v2"
 	cocli "github.com/cohere-ai/cohere-go/v2/client"
 	core "github.com/cohere-ai/cohere-go/v2/core"
+	option "github.com/cohere-ai/cohere-go/v2/option"
 )
 
 const COHERE_API_KEY = "COHERE_API_KEY"
@@ -30,31 +36,84 @@ type cohereProvider struct {
 	client *cocli.Client
 }
 
-func (p *cohereProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message) (io.Reader, error) {
-	var messages []*co.ChatMessage
-	for _, msg := range msgs {
+func (p *cohereProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	start := time.Now()
+	options := newStreamOptions(opts)
+	// tool results following the last assistant message are sent as results of its calls,
+	// Cohere chat history can't represent earlier tool exchanges so those are skipped
+	lastResults := len(msgs)
+	for lastResults > 0 && msgs[lastResults-1].Role == Tool {
+		lastResults--
+	}
+	var (
+		messages    []*co.ChatMessage
+		preamble    []string
+		calls       = make(map[string]*ToolCall)
+		toolResults []*co.ChatStreamRequestToolResultsItem
+	)
+	for i, msg := range msgs {
 		switch msg.Role {
+		case System:
+			preamble = append(preamble, msg.Content)
 		case User:
+			for _, part := range msg.MultiPart {
+				if _, ok := part.Field.(*ImagePart); ok {
+					return nil, fmt.Errorf("images are not supported by Cohere")
+				}
+			}
 			messages = append(messages, &co.ChatMessage{
 				Role:    co.ChatMessageRoleUser,
-				Message: msg.Content,
+				Message: messageText(msg),
 			})
 		case Assistant:
+			for _, call := range msg.ToolCalls {
+				calls[call.ID] = call
+			}
+			if msg.Content == "" {
+				continue
+			}
 			messages = append(messages, &co.ChatMessage{
 				Role:    co.ChatMessageRoleChatbot,
 				Message: msg.Content,
 			})
+		case Tool:
+			if i < lastResults {
+				continue
+			}
+			call, ok := calls[msg.ToolCallID]
+			if !ok {
+				return nil, fmt.Errorf("unknown tool call: %s", msg.ToolCallID)
+			}
+			var params map[string]interface{}
+			if call.Arguments != "" {
+				if err := json.Unmarshal([]byte(call.Arguments), &params); err != nil {
+					return nil, fmt.Errorf("invalid arguments of %s tool call: %w", call.Name, err)
+				}
+			}
+			toolResults = append(toolResults, &co.ChatStreamRequestToolResultsItem{
+				Call:    &co.ToolCall{Name: call.Name, Parameters: params},
+				Outputs: []map[string]interface{}{{"output": msg.Content}},
+			})
 		default:
-			log.Fatalf("unknown role: %s", msg.Role)
+			return nil, fmt.Errorf("unknown role: %s", msg.Role)
 		}
 	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no messages to send")
+	}
+	history, message := messages[:len(messages)-1], messages[len(messages)-1].Message
+	if len(toolResults) > 0 {
+		// model continues with tool results instead of a new message
+		history, message = messages, ""
+	}
 	var model *string
 	if cfg.Model[config.ModelTypeChat] != "" {
 		model = config.Ref(cfg.Model[config.ModelTypeChat])
 	}
 	req := &co.ChatStreamRequest{
-		ChatHistory: messages[:len(messages)-1],
-		Message:     messages[len(messages)-1].Message,
+		ChatHistory: history,
+		Message:     message,
+		ToolResults: toolResults,
 
 		Model:            model,
 		Temperature:      cfg.Temperature,
@@ -62,34 +121,70 @@ func (p *cohereProvider) Stream(ctx context.Context, cfg *config.Config, msgs []
 		K:                cfg.TopK,
 		FrequencyPenalty: cfg.FrequencyPenalty,
 		PresencePenalty:  cfg.PresencePenalty,
+		MaxTokens:        cfg.MaxTokens,
+		StopSequences:    cfg.Stop,
+	}
+	if cfg.Seed != nil {
+		req.Seed = co.Float64(float64(*cfg.Seed))
+	}
+	if len(preamble) > 0 {
+		req.Preamble = co.String(strings.Join(preamble, "\n\n"))
 	}
 	for _, connector := range cfg.Connectors {
 		req.Connectors = append(req.Connectors, &co.ChatConnector{Id: connector})
 	}
-	stream, err := p.client.ChatStream(ctx, req)
+	for _, tool := range options.tools {
+		cohereTool, err := toCohereTool(tool)
+		if err != nil {
+			return nil, err
+		}
+		req.Tools = append(req.Tools, cohereTool)
+	}
+	var reqOpts []option.RequestOption
+	if options.json {
+		// SDK doesn't support response_format yet, so it's patched into request body
+		format := map[string]any{"type": "json_object"}
+		if options.jsonSchema != nil {
+			format["schema"] = options.jsonSchema
+		}
+		reqOpts = append(reqOpts, option.WithHTTPClient(&bodyPatchClient{
+			fields: map[string]any{"response_format": format},
+		}))
+	}
+	stream, err := p.client.ChatStream(ctx, req, reqOpts...)
 	if err != nil {
 		return nil, err
 	}
-	// TODO stream.Close()
-	return &cohereStreamReader{stream: stream}, nil
+	return &cohereStreamReader{stream: stream, usageTracker: newUsageTracker(cfg.Model[config.ModelTypeChat], start)}, nil
 }
 
 func (p *cohereProvider) Transcribe(ctx context.Context, cfg *config.Config, audio *AudioFile) ([]*AudioSegment, error) {
 	return nil, fmt.Errorf("transcription is not supported by Cohere")
 }
 
-func (p *cohereProvider) ListModels(ctx context.Context) ([]string, error) {
+func (p *cohereProvider) ListModels(ctx context.Context) ([]*Model, error) {
 	resp, err := p.client.Models.List(ctx, &co.ModelsListRequest{
 		Endpoint: (*co.CompatibleEndpoint)(co.String(string(co.CompatibleEndpointChat))),
 	})
 	if err != nil {
 		return nil, err
 	}
-	var modelNames []string
+	var models []*Model
 	for _, model := range resp.Models {
-		modelNames = append(modelNames, *model.Name)
+		m := &Model{
+			ID:     *model.Name,
+			Owner:  "cohere",
+			Active: true,
+		}
+		if model.Finetuned != nil && *model.Finetuned {
+			m.Owner = "finetuned"
+		}
+		if model.ContextLength != nil {
+			m.ContextLength = int(*model.ContextLength)
+		}
+		models = append(models, m)
 	}
-	return modelNames, nil
+	return models, nil
 }
 
 func (p *cohereProvider) ListConnectors(ctx context.Context) ([]string, error) {
@@ -104,12 +199,104 @@ func (p *cohereProvider) ListConnectors(ctx context.Context) ([]string, error) {
 	return connectorNames, nil
 }
 
+// bodyPatchClient adds fields to JSON body of requests.
+type bodyPatchClient struct {
+	fields map[string]any
+}
+
+func (c *bodyPatchClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		var body map[string]any
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		for key, value := range c.fields {
+			body[key] = value
+		}
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(data))
+		req.ContentLength = int64(len(data))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// toCohereTool converts JSON schema of tool arguments to Cohere parameter definitions,
+// only top level properties are described.
+func toCohereTool(tool *ToolDefinition) (*co.Tool, error) {
+	var schema struct {
+		Properties map[string]struct {
+			Type        string `json:"type"`
+			Description string `json:"description"`
+		} `json:"properties"`
+		Required []string `json:"required"`
+	}
+	if len(tool.Parameters) > 0 {
+		if err := json.Unmarshal(tool.Parameters, &schema); err != nil {
+			return nil, fmt.Errorf("invalid parameters of %s tool: %w", tool.Name, err)
+		}
+	}
+	cohereTool := &co.Tool{
+		Name:                 tool.Name,
+		Description:          tool.Description,
+		ParameterDefinitions: make(map[string]*co.ToolParameterDefinitionsValue),
+	}
+	for name, prop := range schema.Properties {
+		cohereTool.ParameterDefinitions[name] = &co.ToolParameterDefinitionsValue{
+			Description: prop.Description,
+			Type:        cohereType(prop.Type),
+			Required:    co.Bool(slices.Contains(schema.Required, name)),
+		}
+	}
+	return cohereTool, nil
+}
+
+// cohereType maps JSON schema types to Python type names expected by Cohere.
+func cohereType(t string) string {
+	switch t {
+	case "string":
+		return "str"
+	case "integer":
+		return "int"
+	case "number":
+		return "float"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "List"
+	case "object":
+		return "Dict"
+	default:
+		return t
+	}
+}
+
 type cohereStreamReader struct {
-	stream *core.Stream[co.StreamedChatResponse]
-	buf    []byte
+	usageTracker
+	stream    *core.Stream[co.StreamedChatResponse]
+	buf       []byte
+	toolCalls []*ToolCall
 }
 
-var _ io.Reader = (*cohereStreamReader)(nil)
+var (
+	_ io.ReadCloser = (*cohereStreamReader)(nil)
+	_ ToolCaller    = (*cohereStreamReader)(nil)
+	_ UsageReporter = (*cohereStreamReader)(nil)
+)
+
+func (r *cohereStreamReader) ToolCalls() []*ToolCall {
+	return r.toolCalls
+}
+
+func (r *cohereStreamReader) Close() error {
+	return r.stream.Close()
+}
 
 func (r *cohereStreamReader) Read(p []byte) (int, error) {
 	if len(r.buf) > 0 {
@@ -125,10 +312,42 @@ func (r *cohereStreamReader) Read(p []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	if resp.ToolCallsGeneration != nil {
+		for _, call := range resp.ToolCallsGeneration.ToolCalls {
+			args, err := json.Marshal(call.Parameters)
+			if err != nil {
+				return 0, err
+			}
+			r.toolCalls = append(r.toolCalls, &ToolCall{
+				// Cohere doesn't identify calls
+				ID:        fmt.Sprintf("call_%d", len(r.toolCalls)),
+				Name:      call.Name,
+				Arguments: string(args),
+			})
+		}
+		return 0, nil
+	}
+	if resp.StreamEnd != nil && resp.StreamEnd.Response != nil {
+		// SDK doesn't expose meta, so it's read from raw response
+		var end struct {
+			Meta struct {
+				BilledUnits struct {
+					InputTokens  float64 `json:"input_tokens"`
+					OutputTokens float64 `json:"output_tokens"`
+				} `json:"billed_units"`
+			} `json:"meta"`
+		}
+		if err := json.Unmarshal([]byte(resp.StreamEnd.Response.String()), &end); err == nil {
+			r.usage.PromptTokens = int(end.Meta.BilledUnits.InputTokens)
+			r.usage.CompletionTokens = int(end.Meta.BilledUnits.OutputTokens)
+		}
+		return 0, nil
+	}
 	if resp.TextGeneration == nil {
 		return 0, nil
 	}
 	out := []byte(resp.TextGeneration.Text)
+	r.generated(len(out))
 	n := copy(p, out)
 	if n < len(out) {
 		r.buf = out[n:]
@@ -0,0 +1,226 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daulet/cmd/config"
+)
+
+// FakeScript describes conversation expected by fake provider and its canned responses.
+type FakeScript struct {
+	Models      []string                   `json:"models,omitempty"`
+	Connectors  []string                   `json:"connectors,omitempty"`
+	Transcripts map[string][]*AudioSegment `json:"transcripts,omitempty"`
+	// Turns are consumed in order, one per Stream call.
+	Turns []*FakeTurn `json:"turns"`
+}
+
+type FakeTurn struct {
+	// Expect has to match the tail of the messages sent, empty matches anything.
+	Expect   []*FakeMessage `json:"expect,omitempty"`
+	Response string         `json:"response,omitempty"`
+	// ToolCalls requested by the model after the response
+	ToolCalls []*ToolCall `json:"tool_calls,omitempty"`
+	// Error is returned by Stream instead of the response.
+	Error string `json:"error,omitempty"`
+	// ChunkSize is the size of streamed chunks in bytes, whole response by default.
+	ChunkSize int `json:"chunk_size,omitempty"`
+	// Delay before each chunk, e.g. "50ms".
+	Delay string `json:"delay,omitempty"`
+	// Usage reported for the turn
+	Usage *FakeUsage `json:"usage,omitempty"`
+}
+
+type FakeUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type FakeMessage struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+func ReadFakeScript(path string) (*FakeScript, error) {
+	if path == "" {
+		return nil, fmt.Errorf("Set fake_script in config to use %s provider", config.ProviderFake)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	script := &FakeScript{}
+	if err := json.Unmarshal(data, script); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return script, nil
+}
+
+// NewFakeProvider replays scripted responses, useful for tests and demos.
+func NewFakeProvider(script *FakeScript) (Provider, error) {
+	for i, turn := range script.Turns {
+		if turn.Delay == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(turn.Delay); err != nil {
+			return nil, fmt.Errorf("turn %d: invalid delay: %w", i, err)
+		}
+	}
+	return &fakeProvider{script: script}, nil
+}
+
+var _ Provider = (*fakeProvider)(nil)
+
+type fakeProvider struct {
+	script *FakeScript
+
+	mu   sync.Mutex
+	turn int
+}
+
+func (p *fakeProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	start := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.turn >= len(p.script.Turns) {
+		return nil, fmt.Errorf("fake provider: unexpected turn %d, script has %d turns", p.turn, len(p.script.Turns))
+	}
+	turn := p.script.Turns[p.turn]
+	if err := matchMessages(turn.Expect, msgs); err != nil {
+		return nil, fmt.Errorf("fake provider: turn %d: %w", p.turn, err)
+	}
+	p.turn++
+
+	if turn.Error != "" {
+		return nil, errors.New(turn.Error)
+	}
+	var delay time.Duration
+	if turn.Delay != "" {
+		delay, _ = time.ParseDuration(turn.Delay)
+	}
+	r := &fakeStreamReader{
+		usageTracker: newUsageTracker(cfg.Model[config.ModelTypeChat], start),
+		ctx:          ctx,
+		chunks:       SplitRunes(turn.Response, turn.ChunkSize),
+		delay:        delay,
+		toolCalls:    turn.ToolCalls,
+	}
+	if turn.Usage != nil {
+		r.usage.PromptTokens = turn.Usage.PromptTokens
+		r.usage.CompletionTokens = turn.Usage.CompletionTokens
+	}
+	return r, nil
+}
+
+func (p *fakeProvider) Transcribe(ctx context.Context, cfg *config.Config, audio *AudioFile) ([]*AudioSegment, error) {
+	segments, ok := p.script.Transcripts[audio.FilePath]
+	if !ok {
+		return nil, fmt.Errorf("fake provider: no transcript for %s", audio.FilePath)
+	}
+	return segments, nil
+}
+
+func (p *fakeProvider) ListModels(ctx context.Context) ([]*Model, error) {
+	var models []*Model
+	for _, model := range p.script.Models {
+		models = append(models, &Model{
+			ID:     model,
+			Owner:  "fake",
+			Active: true,
+		})
+	}
+	return models, nil
+}
+
+func (p *fakeProvider) ListConnectors(ctx context.Context) ([]string, error) {
+	return p.script.Connectors, nil
+}
+
+func matchMessages(expect []*FakeMessage, msgs []*Message) error {
+	if len(expect) > len(msgs) {
+		return fmt.Errorf("expected %d messages, got %d", len(expect), len(msgs))
+	}
+	msgs = msgs[len(msgs)-len(expect):]
+	for i, exp := range expect {
+		msg := msgs[i]
+		if exp.Role != msg.Role {
+			return fmt.Errorf("message %d: expected role %s, got %s", i, exp.Role, msg.Role)
+		}
+		if content := messageText(msg); exp.Content != content {
+			return fmt.Errorf("message %d: expected %q, got %q", i, exp.Content, content)
+		}
+	}
+	return nil
+}
+
+// messageText returns text content of the message, joining text parts of multi part message.
+func messageText(msg *Message) string {
+	if msg.Content != "" {
+		return msg.Content
+	}
+	var texts []string
+	for _, part := range msg.MultiPart {
+		if text, ok := part.Field.(*TextPart); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+var (
+	_ io.Reader     = (*fakeStreamReader)(nil)
+	_ ToolCaller    = (*fakeStreamReader)(nil)
+	_ UsageReporter = (*fakeStreamReader)(nil)
+)
+
+type fakeStreamReader struct {
+	usageTracker
+	ctx       context.Context
+	chunks    []string
+	delay     time.Duration
+	buf       []byte
+	toolCalls []*ToolCall
+}
+
+func (r *fakeStreamReader) ToolCalls() []*ToolCall {
+	return r.toolCalls
+}
+
+func (r *fakeStreamReader) Read(p []byte) (int, error) {
+	if len(r.buf) > 0 {
+		n := copy(p, r.buf)
+		if n < len(r.buf) {
+			r.buf = r.buf[n:]
+			return n, nil
+		}
+		r.buf = nil
+		return n, nil
+	}
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	if r.delay > 0 {
+		select {
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		case <-time.After(r.delay):
+		}
+	}
+	out := []byte(r.chunks[0])
+	r.chunks = r.chunks[1:]
+	r.generated(len(out))
+	n := copy(p, out)
+	if n < len(out) {
+		r.buf = out[n:]
+	}
+	return n, nil
+}
@@ -0,0 +1,171 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+
+	"github.com/daulet/cmd/config"
+)
+
+// Fallback is a provider in the fallback chain, Model are models by model type
+// this provider serves, provider defaults are used for the rest.
+type Fallback struct {
+	Name     string
+	Provider Provider
+	Model    map[string]string
+}
+
+// NewFallbackProvider tries providers in order, moving on to the next one when
+// request fails with a rate limit or outage before anything has been streamed.
+// Models and connectors are listed from the first provider only.
+func NewFallbackProvider(chain []*Fallback) (Provider, error) {
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("fallback chain is empty")
+	}
+	return &fallbackProvider{chain: chain}, nil
+}
+
+var _ Provider = (*fallbackProvider)(nil)
+
+type fallbackProvider struct {
+	chain []*Fallback
+}
+
+func (p *fallbackProvider) ListModels(ctx context.Context) ([]*Model, error) {
+	return p.chain[0].Provider.ListModels(ctx)
+}
+
+func (p *fallbackProvider) ListConnectors(ctx context.Context) ([]string, error) {
+	return p.chain[0].Provider.ListConnectors(ctx)
+}
+
+func (p *fallbackProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	var err error
+	for i, fb := range p.chain {
+		if i > 0 {
+			fmt.Fprintf(os.Stderr, "warning: %s failed, falling back to %s: %v\n", p.chain[i-1].Name, fb.Name, err)
+		}
+		var r io.Reader
+		r, err = fb.Provider.Stream(ctx, fb.config(cfg), msgs, opts...)
+		if err != nil {
+			if IsTransient(err) {
+				continue
+			}
+			return nil, err
+		}
+		// some providers only report failure on first read, so it's done
+		// before committing to this provider
+		var first []byte
+		first, err = readFirst(r)
+		if err != nil {
+			closeReader(r)
+			if IsTransient(err) {
+				continue
+			}
+			return nil, err
+		}
+		return &fallbackReader{r: r, first: first, name: fb.Name}, nil
+	}
+	return nil, err
+}
+
+func (p *fallbackProvider) Transcribe(ctx context.Context, cfg *config.Config, audio *AudioFile) ([]*AudioSegment, error) {
+	data, err := io.ReadAll(audio.Reader)
+	if err != nil {
+		return nil, err
+	}
+	for i, fb := range p.chain {
+		if i > 0 {
+			fmt.Fprintf(os.Stderr, "warning: %s failed, falling back to %s: %v\n", p.chain[i-1].Name, fb.Name, err)
+		}
+		var segments []*AudioSegment
+		segments, err = fb.Provider.Transcribe(ctx, fb.config(cfg), &AudioFile{
+			FilePath: audio.FilePath,
+			Reader:   bytes.NewReader(data),
+		})
+		if err == nil || !IsTransient(err) {
+			return segments, err
+		}
+	}
+	return nil, err
+}
+
+// config returns cfg with models of this provider. Models configured for
+// another provider aren't served by this one, so its defaults are used instead.
+func (fb *Fallback) config(cfg *config.Config) *config.Config {
+	if fb.Name == cfg.Provider && len(fb.Model) == 0 {
+		return cfg
+	}
+	c := *cfg
+	c.Provider = fb.Name
+	c.Model = maps.Clone(fb.Model)
+	if c.Model == nil {
+		c.Model = make(map[string]string)
+	}
+	return &c
+}
+
+// readFirst reads until stream produces some output or ends.
+func readFirst(r io.Reader) ([]byte, error) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			return buf[:n], nil
+		}
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+var (
+	_ io.ReadCloser = (*fallbackReader)(nil)
+	_ ToolCaller    = (*fallbackReader)(nil)
+	_ UsageReporter = (*fallbackReader)(nil)
+)
+
+// fallbackReader replays first read output and attributes usage to provider that served it.
+type fallbackReader struct {
+	r     io.Reader
+	first []byte
+	name  string
+}
+
+func (r *fallbackReader) ToolCalls() []*ToolCall {
+	if caller, ok := r.r.(ToolCaller); ok {
+		return caller.ToolCalls()
+	}
+	return nil
+}
+
+func (r *fallbackReader) Usage() *Usage {
+	if reporter, ok := r.r.(UsageReporter); ok {
+		usage := reporter.Usage()
+		if usage != nil && usage.Provider == "" {
+			usage.Provider = r.name
+		}
+		return usage
+	}
+	return nil
+}
+
+func (r *fallbackReader) Close() error {
+	return closeReader(r.r)
+}
+
+func (r *fallbackReader) Read(p []byte) (int, error) {
+	if len(r.first) > 0 {
+		n := copy(p, r.first)
+		r.first = r.first[n:]
+		return n, nil
+	}
+	return r.r.Read(p)
+}
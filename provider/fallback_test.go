@@ -0,0 +1,184 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/daulet/cmd/config"
+)
+
+// stubProvider fails every stream with err and remembers config of the last request.
+type stubProvider struct {
+	err   error
+	calls int
+	cfg   *config.Config
+}
+
+func (p *stubProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	p.calls++
+	p.cfg = cfg
+	return nil, p.err
+}
+
+func (p *stubProvider) Transcribe(ctx context.Context, cfg *config.Config, audio *AudioFile) ([]*AudioSegment, error) {
+	return nil, p.err
+}
+
+func (p *stubProvider) ListModels(ctx context.Context) ([]*Model, error) {
+	return nil, p.err
+}
+
+func (p *stubProvider) ListConnectors(ctx context.Context) ([]string, error) {
+	return nil, p.err
+}
+
+func TestFallbackOnRateLimit(t *testing.T) {
+	primary := &stubProvider{err: &APIError{StatusCode: http.StatusTooManyRequests, Message: "Please try again in 2m0s."}}
+	fake, err := NewFakeProvider(&FakeScript{Turns: []*FakeTurn{{Response: "hello"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary := &recordingProvider{Provider: fake}
+	p, err := NewFallbackProvider([]*Fallback{
+		{Name: config.ProviderGroq, Provider: primary},
+		{Name: config.ProviderCohere, Provider: NewRetryProvider(secondary, nil)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Provider: config.ProviderGroq,
+		Model:    map[string]string{config.ModelTypeChat: "llama-3.1-8b-instant"},
+	}
+
+	r, err := p.Stream(context.Background(), cfg, []*Message{{Role: User, Content: "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("response = %q, want %q", data, "hello")
+	}
+	if model := primary.cfg.Model[config.ModelTypeChat]; model != "llama-3.1-8b-instant" {
+		t.Errorf("primary chat model = %q, want configured one", model)
+	}
+	if model := secondary.cfg.Model[config.ModelTypeChat]; model != "" {
+		t.Errorf("fallback chat model = %q, want provider default", model)
+	}
+}
+
+func TestFallbackFailsFast(t *testing.T) {
+	tests := []struct {
+		name string
+		// primary is how the first provider of the chain fails
+		primary func(t *testing.T) (Provider, *stubProvider)
+	}{
+		{
+			name: "rate limited",
+			primary: func(t *testing.T) (Provider, *stubProvider) {
+				stub := &stubProvider{err: &APIError{StatusCode: http.StatusTooManyRequests, Message: "Please try again in 2m0s."}}
+				return stub, stub
+			},
+		},
+		{
+			name: "server error",
+			primary: func(t *testing.T) (Provider, *stubProvider) {
+				stub := &stubProvider{err: &APIError{StatusCode: http.StatusServiceUnavailable}}
+				return stub, stub
+			},
+		},
+		{
+			name: "connection reset",
+			primary: func(t *testing.T) (Provider, *stubProvider) {
+				stub := &stubProvider{err: fmt.Errorf("read: %w", syscall.ECONNRESET)}
+				return stub, stub
+			},
+		},
+		{
+			name: "client side rate limit",
+			primary: func(t *testing.T) (Provider, *stubProvider) {
+				stub := &stubProvider{}
+				limits := map[string]*config.RateLimit{config.ProviderGroq: {RequestsPerMinute: 1}}
+				p := NewFailFastRateLimitProvider(stub, config.ProviderGroq, limits, "").(*rateLimitProvider)
+				// the only request of the minute is already taken
+				if err := p.acquire(context.Background(), config.ProviderGroq, limits[config.ProviderGroq], 0); err != nil {
+					t.Fatal(err)
+				}
+				return p, stub
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, stub := tt.primary(t)
+			fake, err := NewFakeProvider(&FakeScript{Turns: []*FakeTurn{{Response: "hello"}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := NewFallbackProvider([]*Fallback{
+				{Name: config.ProviderGroq, Provider: primary},
+				{Name: config.ProviderCohere, Provider: NewRetryProvider(fake, nil)},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{Provider: config.ProviderGroq}
+
+			start := time.Now()
+			r, err := p.Stream(context.Background(), cfg, []*Message{{Role: User, Content: "hi"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "hello" {
+				t.Errorf("response = %q, want %q", data, "hello")
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("waited %s before falling back", elapsed)
+			}
+			if stub.err != nil && stub.calls != 1 {
+				t.Errorf("primary was called %d times, want 1", stub.calls)
+			}
+			if stub.err == nil && stub.calls != 0 {
+				t.Errorf("primary was called over the limit")
+			}
+		})
+	}
+}
+
+func TestFallbackModels(t *testing.T) {
+	cfg := &config.Config{
+		Provider: config.ProviderGroq,
+		Model:    map[string]string{config.ModelTypeChat: "llama-3.1-8b-instant"},
+	}
+	fb := &Fallback{Name: config.ProviderCohere, Model: map[string]string{config.ModelTypeChat: "command-r"}}
+	c := fb.config(cfg)
+	if got := c.Model[config.ModelTypeChat]; got != "command-r" {
+		t.Errorf("chat model = %q, want %q", got, "command-r")
+	}
+	if cfg.Model[config.ModelTypeChat] != "llama-3.1-8b-instant" {
+		t.Errorf("config of primary provider was modified")
+	}
+}
+
+// recordingProvider remembers config of the last stream request.
+type recordingProvider struct {
+	Provider
+	cfg *config.Config
+}
+
+func (p *recordingProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	p.cfg = cfg
+	return p.Provider.Stream(ctx, cfg, msgs, opts...)
+}
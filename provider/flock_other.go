@@ -0,0 +1,14 @@
+//go:build !unix
+
+package provider
+
+import "os"
+
+// lockFile is a no-op, so rate limit state is only shared within the process.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
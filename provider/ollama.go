@@ -0,0 +1,254 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/daulet/cmd/config"
+)
+
+const (
+	OLLAMA_HOST = "OLLAMA_HOST"
+
+	DEFAULT_OLLAMA_HOST             = "http://localhost:11434"
+	DEFAULT_OLLAMA_CHAT_MODEL       = "llama3.2"
+	DEFAULT_OLLAMA_CHAT_IMAGE_MODEL = "llava"
+)
+
+// NewOllamaProvider talks to local Ollama server, set OLLAMA_HOST to use non default address.
+func NewOllamaProvider() (Provider, error) {
+	host := os.Getenv(OLLAMA_HOST)
+	if host == "" {
+		host = DEFAULT_OLLAMA_HOST
+	}
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "http://" + host
+	}
+	return &ollamaProvider{
+		client:  http.DefaultClient,
+		baseURL: strings.TrimSuffix(host, "/"),
+	}, nil
+}
+
+var _ Provider = (*ollamaProvider)(nil)
+
+type ollamaProvider struct {
+	client  *http.Client
+	baseURL string
+}
+
+type ollamaRequest struct {
+	Model    string           `json:"model"`
+	Messages []*ollamaMessage `json:"messages"`
+	Stream   bool             `json:"stream"`
+	// Format is either "json" or JSON schema
+	Format  json.RawMessage `json:"format,omitempty"`
+	Options *ollamaOptions  `json:"options,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Images are base64 encoded, without data URL prefix
+	Images []string `json:"images,omitempty"`
+}
+
+type ollamaOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+func (p *ollamaProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	start := time.Now()
+	options := newStreamOptions(opts)
+	if len(options.tools) > 0 {
+		return nil, fmt.Errorf("tools are not supported by Ollama provider")
+	}
+	var (
+		messages []*ollamaMessage
+		hasImage bool
+	)
+	for _, msg := range msgs {
+		switch msg.Role {
+		case System, User, Assistant:
+		default:
+			return nil, fmt.Errorf("unknown role: %s", msg.Role)
+		}
+		message := &ollamaMessage{
+			Role:    string(msg.Role),
+			Content: msg.Content,
+		}
+		var texts []string
+		for _, part := range msg.MultiPart {
+			switch field := part.Field.(type) {
+			case *ImagePart:
+				_, data, err := parseDataURL(field.Data)
+				if err != nil {
+					return nil, err
+				}
+				message.Images = append(message.Images, data)
+				hasImage = true
+			case *TextPart:
+				texts = append(texts, field.Text)
+			default:
+				panic("unknown part type")
+			}
+		}
+		if len(texts) > 0 {
+			message.Content = strings.Join(texts, "\n")
+		}
+		messages = append(messages, message)
+	}
+
+	model := DEFAULT_OLLAMA_CHAT_MODEL
+	if cfg.Model[config.ModelTypeChat] != "" {
+		model = cfg.Model[config.ModelTypeChat]
+	}
+	if hasImage && (cfg.Model[config.ModelTypeChatImage] != "" || !supportsVision(cfg, model)) {
+		model = DEFAULT_OLLAMA_CHAT_IMAGE_MODEL
+		if cfg.Model[config.ModelTypeChatImage] != "" {
+			model = cfg.Model[config.ModelTypeChatImage]
+		}
+	}
+	var format json.RawMessage
+	if options.json {
+		format = json.RawMessage(`"json"`)
+		if options.jsonSchema != nil {
+			format = options.jsonSchema
+		}
+	}
+	resp, err := doJSON(ctx, p.client, http.MethodPost, p.baseURL+"/api/chat", nil, &ollamaRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   true,
+		Format:   format,
+		Options: &ollamaOptions{
+			Temperature:      cfg.Temperature,
+			TopP:             cfg.TopP,
+			TopK:             cfg.TopK,
+			FrequencyPenalty: cfg.FrequencyPenalty,
+			PresencePenalty:  cfg.PresencePenalty,
+			NumPredict:       cfg.MaxTokens,
+			Stop:             cfg.Stop,
+			Seed:             cfg.Seed,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ollamaStreamReader{
+		usageTracker: newUsageTracker(model, start),
+		body:         resp.Body,
+		decoder:      json.NewDecoder(resp.Body),
+	}, nil
+}
+
+func (p *ollamaProvider) Transcribe(ctx context.Context, cfg *config.Config, audio *AudioFile) ([]*AudioSegment, error) {
+	return nil, fmt.Errorf("transcription is not supported by Ollama")
+}
+
+func (p *ollamaProvider) ListModels(ctx context.Context) ([]*Model, error) {
+	resp, err := doJSON(ctx, p.client, http.MethodGet, p.baseURL+"/api/tags", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+	var models []*Model
+	for _, model := range tags.Models {
+		models = append(models, &Model{
+			ID:     model.Name,
+			Owner:  "local",
+			Active: true,
+		})
+	}
+	return models, nil
+}
+
+func (p *ollamaProvider) ListConnectors(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+var (
+	_ io.ReadCloser = (*ollamaStreamReader)(nil)
+	_ UsageReporter = (*ollamaStreamReader)(nil)
+)
+
+// ollamaStreamReader reads newline delimited JSON chunks.
+type ollamaStreamReader struct {
+	usageTracker
+	body    io.ReadCloser
+	decoder *json.Decoder
+	buf     []byte
+}
+
+func (r *ollamaStreamReader) Close() error {
+	return r.body.Close()
+}
+
+func (r *ollamaStreamReader) Read(p []byte) (int, error) {
+	if len(r.buf) > 0 {
+		n := copy(p, r.buf)
+		if n < len(r.buf) {
+			r.buf = r.buf[n:]
+			return n, nil
+		}
+		r.buf = nil
+		return n, nil
+	}
+	var chunk struct {
+		Message *ollamaMessage `json:"message"`
+		Done    bool           `json:"done"`
+		Error   string         `json:"error"`
+		// usage is reported in the last chunk
+		PromptEvalCount int `json:"prompt_eval_count"`
+		EvalCount       int `json:"eval_count"`
+	}
+	if err := r.decoder.Decode(&chunk); err != nil {
+		r.body.Close()
+		if errors.Is(err, io.EOF) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	if chunk.Error != "" {
+		r.body.Close()
+		return 0, errors.New(chunk.Error)
+	}
+	if chunk.Done {
+		r.usage.PromptTokens = chunk.PromptEvalCount
+		r.usage.CompletionTokens = chunk.EvalCount
+		r.body.Close()
+		return 0, io.EOF
+	}
+	if chunk.Message == nil {
+		return 0, nil
+	}
+	out := []byte(chunk.Message.Content)
+	r.generated(len(out))
+	n := copy(p, out)
+	if n < len(out) {
+		r.buf = out[n:]
+	}
+	return n, nil
+}
@@ -2,10 +2,14 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"math"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/daulet/cmd/config"
 	"github.com/sashabaranov/go-openai"
@@ -25,25 +29,76 @@ func NewGroqProvider() (Provider, error) {
 	if apiKey == "" {
 		return nil, fmt.Errorf("Set %s env variable to your Groq API key. Get one at https://console.groq.com/keys", GROQ_API_KEY)
 	}
+	return newOpenAIProvider("https://api.groq.com/openai/v1", apiKey, groqDefaultModels), nil
+}
+
+var groqDefaultModels = map[string]string{
+	config.ModelTypeChat:         DEFAULT_CHAT_MODEL,
+	config.ModelTypeChatImage:    DEFAULT_CHAT_IMAGE_MODEL,
+	config.ModelTypeSpeechToText: DEFAULT_AUDIO_MODEL,
+}
+
+// NewOpenAICompatibleProvider works with any server implementing OpenAI API,
+// e.g. vLLM or llama.cpp.
+func NewOpenAICompatibleProvider(cfg *config.OpenAICompatibleConfig) (Provider, error) {
+	if cfg == nil || cfg.BaseURL == "" {
+		return nil, fmt.Errorf("Set openai_compatible.base_url in config to use %s provider", config.ProviderOpenAICompatible)
+	}
+	var apiKey string
+	if cfg.APIKeyEnv != "" {
+		apiKey = os.Getenv(cfg.APIKeyEnv)
+		if apiKey == "" {
+			return nil, fmt.Errorf("Set %s env variable to your API key for %s", cfg.APIKeyEnv, cfg.BaseURL)
+		}
+	}
+	return newOpenAIProvider(cfg.BaseURL, apiKey, cfg.DefaultModel), nil
+}
 
+func newOpenAIProvider(baseURL, apiKey string, defaultModel map[string]string) *openAIProvider {
 	config := openai.DefaultConfig(apiKey)
-	config.BaseURL = "https://api.groq.com/openai/v1"
+	config.BaseURL = baseURL
 	client := openai.NewClientWithConfig(config)
 
-	return &openAIProvider{client: client}, nil
+	return &openAIProvider{
+		client:       client,
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		apiKey:       apiKey,
+		defaultModel: defaultModel,
+	}
 }
 
 var _ Provider = (*openAIProvider)(nil)
 
 type openAIProvider struct {
-	client *openai.Client
+	client       *openai.Client
+	baseURL      string
+	apiKey       string
+	defaultModel map[string]string
 }
 
-func (p *openAIProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message) (io.Reader, error) {
+// model returns configured model for the type, falling back to provider default.
+func (p *openAIProvider) model(cfg *config.Config, modelType string) (string, error) {
+	if cfg.Model[modelType] != "" {
+		return cfg.Model[modelType], nil
+	}
+	if p.defaultModel[modelType] != "" {
+		return p.defaultModel[modelType], nil
+	}
+	return "", fmt.Errorf("no %s model is set, use --model to set one", modelType)
+}
+
+func (p *openAIProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	start := time.Now()
+	options := newStreamOptions(opts)
 	var messages []openai.ChatCompletionMessage
 	hasImage := false
 	for _, msg := range msgs {
 		switch msg.Role {
+		case System:
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: msg.Content,
+			})
 		case User:
 			if msg.Content == "" {
 				chatMessage := openai.ChatCompletionMessage{
@@ -76,40 +131,98 @@ func (p *openAIProvider) Stream(ctx context.Context, cfg *config.Config, msgs []
 				})
 			}
 		case Assistant:
-			messages = append(messages, openai.ChatCompletionMessage{
+			chatMessage := openai.ChatCompletionMessage{
 				Role:    openai.ChatMessageRoleAssistant,
 				Content: msg.Content,
+			}
+			for _, call := range msg.ToolCalls {
+				chatMessage.ToolCalls = append(chatMessage.ToolCalls, openai.ToolCall{
+					ID:   call.ID,
+					Type: openai.ToolTypeFunction,
+					Function: openai.FunctionCall{
+						Name:      call.Name,
+						Arguments: call.Arguments,
+					},
+				})
+			}
+			messages = append(messages, chatMessage)
+		case Tool:
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    msg.Content,
+				ToolCallID: msg.ToolCallID,
 			})
 		default:
-			log.Fatalf("unknown role: %s", msg.Role)
+			return nil, fmt.Errorf("unknown role: %s", msg.Role)
 		}
 	}
 
-	model := DEFAULT_CHAT_MODEL
-	if cfg.Model[config.ModelTypeChat] != "" {
-		model = cfg.Model[config.ModelTypeChat]
+	model, err := p.model(cfg, config.ModelTypeChat)
+	if err != nil {
+		return nil, err
 	}
-	if hasImage {
-		model = DEFAULT_CHAT_IMAGE_MODEL
-		if cfg.Model[config.ModelTypeChatImage] != "" {
-			model = cfg.Model[config.ModelTypeChatImage]
+	if hasImage && (cfg.Model[config.ModelTypeChatImage] != "" || !supportsVision(cfg, model)) {
+		// prefer selected image model, otherwise use chat model if it can see
+		model, err = p.model(cfg, config.ModelTypeChatImage)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	stream, err := p.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+	if cfg.TopK != nil {
+		fmt.Fprintf(os.Stderr, "warning: top_k is not supported by OpenAI API, ignoring it\n")
+	}
+	req := openai.ChatCompletionRequest{
 		Model:    model,
 		Messages: messages,
-	})
+
+		Temperature:      float32Value(cfg.Temperature),
+		TopP:             float32Value(cfg.TopP),
+		FrequencyPenalty: float32Value(cfg.FrequencyPenalty),
+		PresencePenalty:  float32Value(cfg.PresencePenalty),
+		Stop:             cfg.Stop,
+		Seed:             cfg.Seed,
+
+		StreamOptions: &openai.StreamOptions{IncludeUsage: true},
+	}
+	if cfg.MaxTokens != nil {
+		req.MaxTokens = *cfg.MaxTokens
+	}
+	if options.json {
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+		}
+		if options.jsonSchema != nil {
+			req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+				Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+				JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+					Name:   "response",
+					Schema: options.jsonSchema,
+				},
+			}
+		}
+	}
+	for _, tool := range options.tools {
+		req.Tools = append(req.Tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	stream, err := p.client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
 		return nil, err
 	}
-	return &openaiStreamReader{stream: stream}, nil
+	return &openaiStreamReader{stream: stream, usageTracker: newUsageTracker(model, start)}, nil
 }
 
 func (p *openAIProvider) Transcribe(ctx context.Context, cfg *config.Config, audio *AudioFile) ([]*AudioSegment, error) {
-	model := DEFAULT_AUDIO_MODEL
-	if cfg.Model[config.ModelTypeSpeechToText] != "" {
-		model = cfg.Model[config.ModelTypeSpeechToText]
+	model, err := p.model(cfg, config.ModelTypeSpeechToText)
+	if err != nil {
+		return nil, err
 	}
 	res, err := p.client.CreateTranscription(ctx, openai.AudioRequest{
 		Model:    model,
@@ -133,27 +246,75 @@ func (p *openAIProvider) Transcribe(ctx context.Context, cfg *config.Config, aud
 	return segments, nil
 }
 
-func (p *openAIProvider) ListModels(ctx context.Context) ([]string, error) {
-	models, err := p.client.ListModels(ctx)
+func (p *openAIProvider) ListModels(ctx context.Context) ([]*Model, error) {
+	// go-openai doesn't expose extra fields like context_window and active returned by Groq
+	header := http.Header{}
+	if p.apiKey != "" {
+		header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	resp, err := doJSON(ctx, http.DefaultClient, http.MethodGet, p.baseURL+"/models", header, nil)
 	if err != nil {
 		return nil, err
 	}
-	var modelNames []string
-	for _, model := range models.Models {
-		modelNames = append(modelNames, model.ID)
+	defer resp.Body.Close()
+	var models struct {
+		Data []struct {
+			ID            string `json:"id"`
+			OwnedBy       string `json:"owned_by"`
+			Active        *bool  `json:"active"`
+			ContextWindow int    `json:"context_window"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return nil, err
+	}
+	var res []*Model
+	for _, model := range models.Data {
+		res = append(res, &Model{
+			ID:            model.ID,
+			Owner:         model.OwnedBy,
+			ContextLength: model.ContextWindow,
+			Active:        model.Active == nil || *model.Active,
+		})
 	}
-	return modelNames, nil
+	return res, nil
 }
 
 func (p *openAIProvider) ListConnectors(ctx context.Context) ([]string, error) {
 	return nil, nil
 }
 
-var _ io.Reader = (*openaiStreamReader)(nil)
+// float32Value converts optional config value. OpenAI client omits zero values,
+// so explicit zero is sent as the smallest value that isn't, e.g. for greedy sampling.
+func float32Value(v *float64) float32 {
+	if v == nil {
+		return 0
+	}
+	if *v == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return float32(*v)
+}
+
+var (
+	_ io.ReadCloser = (*openaiStreamReader)(nil)
+	_ ToolCaller    = (*openaiStreamReader)(nil)
+	_ UsageReporter = (*openaiStreamReader)(nil)
+)
 
 type openaiStreamReader struct {
-	stream *openai.ChatCompletionStream
-	buf    []byte
+	usageTracker
+	stream    *openai.ChatCompletionStream
+	buf       []byte
+	toolCalls toolCallsAccumulator
+}
+
+func (r *openaiStreamReader) ToolCalls() []*ToolCall {
+	return r.toolCalls.calls
+}
+
+func (r *openaiStreamReader) Close() error {
+	return r.stream.Close()
 }
 
 func (r *openaiStreamReader) Read(p []byte) (int, error) {
@@ -170,7 +331,23 @@ func (r *openaiStreamReader) Read(p []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	out := []byte(resp.Choices[0].Delta.Content)
+	if resp.Usage != nil {
+		r.usage.PromptTokens = resp.Usage.PromptTokens
+		r.usage.CompletionTokens = resp.Usage.CompletionTokens
+	}
+	if len(resp.Choices) == 0 {
+		return 0, nil
+	}
+	delta := resp.Choices[0].Delta
+	for i, call := range delta.ToolCalls {
+		index := i
+		if call.Index != nil {
+			index = *call.Index
+		}
+		r.toolCalls.add(index, call.ID, call.Function.Name, call.Function.Arguments)
+	}
+	out := []byte(delta.Content)
+	r.generated(len(out))
 	n := copy(p, out)
 	if n < len(out) {
 		r.buf = out[n:]
@@ -0,0 +1,36 @@
+package provider
+
+import "encoding/json"
+
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	tools []*ToolDefinition
+	// json requests JSON output, constrained by jsonSchema if it's set
+	json       bool
+	jsonSchema json.RawMessage
+}
+
+// WithTools makes tools available to the model.
+func WithTools(tools ...*ToolDefinition) StreamOption {
+	return func(o *streamOptions) {
+		o.tools = append(o.tools, tools...)
+	}
+}
+
+// WithJSON requests JSON output from providers that support it,
+// schema is optional and not every provider enforces it.
+func WithJSON(schema json.RawMessage) StreamOption {
+	return func(o *streamOptions) {
+		o.json = true
+		o.jsonSchema = schema
+	}
+}
+
+func newStreamOptions(opts []StreamOption) *streamOptions {
+	o := &streamOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
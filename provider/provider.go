@@ -1,8 +1,10 @@
 package provider
 
 import (
+	"cmp"
 	"context"
 	"io"
+	"maps"
 
 	"github.com/daulet/cmd/config"
 )
@@ -10,14 +12,21 @@ import (
 type Role string
 
 const (
+	System    Role = "system"
 	User      Role = "user"
 	Assistant Role = "assistant"
+	// Tool messages carry results of tool calls
+	Tool Role = "tool"
 )
 
 type Message struct {
 	Role      Role
 	Content   string
 	MultiPart []*MessagePart
+	// ToolCalls requested by Assistant
+	ToolCalls []*ToolCall `json:",omitempty"`
+	// ToolCallID is set for Tool message to the call it is result of
+	ToolCallID string `json:",omitempty"`
 }
 
 var _ OneOf = (*ImagePart)(nil)
@@ -55,9 +64,76 @@ type AudioSegment struct {
 	End   float64
 }
 
+// Model describes a model as reported by provider, zero values mean unknown.
+type Model struct {
+	ID            string              `json:"id"`
+	Owner         string              `json:"owner,omitempty"`
+	ContextLength int                 `json:"context_length,omitempty"`
+	Capabilities  []config.Capability `json:"capabilities,omitempty"`
+	Active        bool                `json:"active"`
+}
+
 type Provider interface {
-	ListModels(ctx context.Context) ([]string, error)
+	ListModels(ctx context.Context) ([]*Model, error)
 	ListConnectors(ctx context.Context) ([]string, error)
-	Stream(ctx context.Context, cfg *config.Config, msgs []*Message) (io.Reader, error)
+	// Stream returns reader of generated text, readers of HTTP streams implement
+	// io.Closer to release the connection when generation is abandoned.
+	Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error)
 	Transcribe(ctx context.Context, cfg *config.Config, audio *AudioFile) ([]*AudioSegment, error)
 }
+
+// closeReader closes r if it's a closer, used by wrappers to forward Close.
+func closeReader(r io.Reader) error {
+	if closer, ok := r.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// DefaultModels returns models by model type configured provider uses when they
+// aren't set in config, model types chosen by the API itself are missing.
+func DefaultModels(cfg *config.Config) map[string]string {
+	switch cfg.Provider {
+	case config.ProviderGroq:
+		return maps.Clone(groqDefaultModels)
+	case config.ProviderOpenAICompatible:
+		if cfg.OpenAICompatible != nil {
+			return maps.Clone(cfg.OpenAICompatible.DefaultModel)
+		}
+	case config.ProviderAnthropic:
+		return map[string]string{config.ModelTypeChat: DEFAULT_ANTHROPIC_CHAT_MODEL}
+	case config.ProviderOllama:
+		return map[string]string{
+			config.ModelTypeChat:      DEFAULT_OLLAMA_CHAT_MODEL,
+			config.ModelTypeChatImage: DEFAULT_OLLAMA_CHAT_IMAGE_MODEL,
+		}
+	}
+	return nil
+}
+
+// ImageModel returns model provider serves messages with images with: selected image
+// model, otherwise chat model if it can see, otherwise default image model.
+func ImageModel(cfg *config.Config) string {
+	chat, image := cfg.Model[config.ModelTypeChat], cfg.Model[config.ModelTypeChatImage]
+	if cfg.Provider == config.ProviderAnthropic {
+		// Anthropic leaves models of other providers and has no separate image model
+		if image != "" && !otherProviderModel(cfg, image) {
+			return image
+		}
+		if chat != "" && !otherProviderModel(cfg, chat) {
+			return chat
+		}
+		return DEFAULT_ANTHROPIC_CHAT_MODEL
+	}
+	defaults := DefaultModels(cfg)
+	chat = cmp.Or(chat, defaults[config.ModelTypeChat])
+	if image != "" || !supportsVision(cfg, chat) {
+		return cmp.Or(image, defaults[config.ModelTypeChatImage], chat)
+	}
+	return chat
+}
+
+func supportsVision(cfg *config.Config, model string) bool {
+	info, err := cfg.LookupModel(model)
+	return err == nil && info.Has(config.CapabilityVision)
+}
@@ -0,0 +1,316 @@
+package provider
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/daulet/cmd/config"
+)
+
+// NewRateLimitProvider limits requests and tokens per minute of provider named name,
+// limits are looked up by "name/model" and then by "name". Buckets are kept in
+// statePath shared by concurrent invocations, empty statePath keeps them in memory.
+func NewRateLimitProvider(p Provider, name string, limits map[string]*config.RateLimit, statePath string) Provider {
+	return &rateLimitProvider{
+		p:         p,
+		name:      name,
+		limits:    limits,
+		statePath: statePath,
+		state:     make(map[string]*bucket),
+	}
+}
+
+// NewFailFastRateLimitProvider limits like NewRateLimitProvider, except it fails
+// with a rate limit error instead of waiting, e.g. so fallback provider is tried.
+func NewFailFastRateLimitProvider(p Provider, name string, limits map[string]*config.RateLimit, statePath string) Provider {
+	r := NewRateLimitProvider(p, name, limits, statePath).(*rateLimitProvider)
+	r.failFast = true
+	return r
+}
+
+var _ Provider = (*rateLimitProvider)(nil)
+
+type rateLimitProvider struct {
+	p         Provider
+	name      string
+	limits    map[string]*config.RateLimit
+	statePath string
+	// failFast returns rate limit error instead of waiting
+	failFast bool
+
+	mu sync.Mutex
+	// state is used when there is no state file
+	state map[string]*bucket
+}
+
+// bucket is a token bucket per limit, refilled continuously up to a minute worth
+// of requests and tokens. Tokens can go negative when estimate was too low.
+type bucket struct {
+	Requests float64   `json:"requests"`
+	Tokens   float64   `json:"tokens"`
+	Updated  time.Time `json:"updated"`
+}
+
+func (p *rateLimitProvider) ListModels(ctx context.Context) ([]*Model, error) {
+	return p.p.ListModels(ctx)
+}
+
+func (p *rateLimitProvider) ListConnectors(ctx context.Context) ([]string, error) {
+	return p.p.ListConnectors(ctx)
+}
+
+func (p *rateLimitProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	key, limit := p.limit(chatModel(cfg, msgs))
+	if limit == nil || limit.TokensPerMinute == 0 && limit.RequestsPerMinute == 0 {
+		return p.p.Stream(ctx, cfg, msgs, opts...)
+	}
+	var estimate int
+	if limit.TokensPerMinute > 0 {
+		estimate = estimateTokens(msgs)
+	}
+	if err := p.acquire(ctx, key, limit, estimate); err != nil {
+		return nil, err
+	}
+	r, err := p.p.Stream(ctx, cfg, msgs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if limit.TokensPerMinute == 0 {
+		return r, nil
+	}
+	return &rateLimitReader{
+		r: r,
+		done: func(usage *Usage) {
+			// charge the difference between actual and estimated tokens
+			actual := usage.PromptTokens + usage.CompletionTokens
+			if err := p.update(key, limit, func(b *bucket) {
+				b.Tokens -= float64(actual - estimate)
+			}); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to update rate limit state: %v\n", err)
+			}
+		},
+	}, nil
+}
+
+func (p *rateLimitProvider) Transcribe(ctx context.Context, cfg *config.Config, audio *AudioFile) ([]*AudioSegment, error) {
+	key, limit := p.limit(cmp.Or(cfg.Model[config.ModelTypeSpeechToText], DefaultModels(cfg)[config.ModelTypeSpeechToText]))
+	if limit != nil {
+		if err := p.acquire(ctx, key, limit, 0); err != nil {
+			return nil, err
+		}
+	}
+	return p.p.Transcribe(ctx, cfg, audio)
+}
+
+func (p *rateLimitProvider) limit(model string) (string, *config.RateLimit) {
+	if model != "" {
+		key := p.name + "/" + model
+		if limit, ok := p.limits[key]; ok {
+			return key, limit
+		}
+	}
+	return p.name, p.limits[p.name]
+}
+
+// chatModel returns model provider is going to serve msgs with, so limits of
+// default and image models apply too.
+func chatModel(cfg *config.Config, msgs []*Message) string {
+	if hasImage(msgs) {
+		return ImageModel(cfg)
+	}
+	return cmp.Or(cfg.Model[config.ModelTypeChat], DefaultModels(cfg)[config.ModelTypeChat])
+}
+
+func hasImage(msgs []*Message) bool {
+	for _, msg := range msgs {
+		for _, part := range msg.MultiPart {
+			if _, ok := part.Field.(*ImagePart); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acquire waits until bucket has a request and tokens available and takes them.
+func (p *rateLimitProvider) acquire(ctx context.Context, key string, limit *config.RateLimit, tokens int) error {
+	if limit.TokensPerMinute > 0 && tokens > limit.TokensPerMinute {
+		// would never fit, so only wait for the bucket to be full
+		tokens = limit.TokensPerMinute
+	}
+	for {
+		var wait time.Duration
+		err := p.update(key, limit, func(b *bucket) {
+			wait = 0
+			if limit.RequestsPerMinute > 0 && b.Requests < 1 {
+				wait = max(wait, refillTime(1-b.Requests, limit.RequestsPerMinute))
+			}
+			if limit.TokensPerMinute > 0 && b.Tokens < float64(tokens) {
+				wait = max(wait, refillTime(float64(tokens)-b.Tokens, limit.TokensPerMinute))
+			}
+			if wait > 0 {
+				return
+			}
+			b.Requests--
+			b.Tokens -= float64(tokens)
+		})
+		if err != nil {
+			return err
+		}
+		if wait == 0 {
+			return nil
+		}
+		if p.failFast {
+			return &APIError{
+				StatusCode: http.StatusTooManyRequests,
+				Message:    fmt.Sprintf("client side rate limit of %s reached, try again in %s", key, wait.Round(time.Millisecond)),
+			}
+		}
+		fmt.Fprintf(os.Stderr, "rate limit of %s reached, waiting %s\n", key, wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func refillTime(amount float64, perMinute int) time.Duration {
+	return time.Duration(amount / float64(perMinute) * float64(time.Minute))
+}
+
+// update refills bucket of key and applies fn to it, holding the state file lock.
+func (p *rateLimitProvider) update(key string, limit *config.RateLimit, fn func(*bucket)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.statePath == "" {
+		b, ok := p.state[key]
+		if !ok {
+			b = newBucket(limit)
+			p.state[key] = b
+		}
+		refill(b, limit)
+		fn(b)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.statePath), fs.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p.statePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	state := make(map[string]*bucket)
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &state); err != nil {
+			// state is only an optimization, start over if it got corrupted
+			state = make(map[string]*bucket)
+		}
+	}
+	b, ok := state[key]
+	if !ok {
+		b = newBucket(limit)
+		state[key] = b
+	}
+	refill(b, limit)
+	fn(b)
+
+	data, err = json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(data, 0)
+	return err
+}
+
+func newBucket(limit *config.RateLimit) *bucket {
+	return &bucket{
+		Requests: float64(limit.RequestsPerMinute),
+		Tokens:   float64(limit.TokensPerMinute),
+		Updated:  time.Now(),
+	}
+}
+
+func refill(b *bucket, limit *config.RateLimit) {
+	now := time.Now()
+	elapsed := now.Sub(b.Updated).Minutes()
+	b.Updated = now
+	if elapsed <= 0 {
+		return
+	}
+	b.Requests = min(b.Requests+elapsed*float64(limit.RequestsPerMinute), float64(limit.RequestsPerMinute))
+	b.Tokens = min(b.Tokens+elapsed*float64(limit.TokensPerMinute), float64(limit.TokensPerMinute))
+}
+
+func estimateTokens(msgs []*Message) int {
+	var tokens int
+	for _, msg := range msgs {
+		tokens += EstimateTokens(messageText(msg))
+	}
+	return tokens
+}
+
+var (
+	_ io.ReadCloser = (*rateLimitReader)(nil)
+	_ ToolCaller    = (*rateLimitReader)(nil)
+	_ UsageReporter = (*rateLimitReader)(nil)
+)
+
+// rateLimitReader reports usage once stream is done.
+type rateLimitReader struct {
+	r    io.Reader
+	done func(*Usage)
+}
+
+func (r *rateLimitReader) ToolCalls() []*ToolCall {
+	if caller, ok := r.r.(ToolCaller); ok {
+		return caller.ToolCalls()
+	}
+	return nil
+}
+
+func (r *rateLimitReader) Usage() *Usage {
+	if reporter, ok := r.r.(UsageReporter); ok {
+		return reporter.Usage()
+	}
+	return nil
+}
+
+func (r *rateLimitReader) Close() error {
+	return closeReader(r.r)
+}
+
+func (r *rateLimitReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF && r.done != nil {
+		if usage := r.Usage(); usage != nil {
+			r.done(usage)
+		}
+		r.done = nil
+	}
+	return n, err
+}
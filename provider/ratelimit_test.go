@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daulet/cmd/config"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	limits := map[string]*config.RateLimit{
+		"groq":                              {RequestsPerMinute: 10},
+		"groq/" + DEFAULT_CHAT_MODEL:        {RequestsPerMinute: 20},
+		"groq/" + DEFAULT_CHAT_IMAGE_MODEL:  {RequestsPerMinute: 30},
+		"groq/" + DEFAULT_AUDIO_MODEL:       {RequestsPerMinute: 40},
+		"groq/llama-3.3-70b-versatile":      {RequestsPerMinute: 50},
+		"groq/llama-3.2-90b-vision-preview": {RequestsPerMinute: 60},
+	}
+	image := []*Message{{Role: User, MultiPart: []*MessagePart{
+		{Field: &TextPart{Text: "what is it?"}},
+		{Field: &ImagePart{Data: "data:image/png;base64,"}},
+	}}}
+	text := []*Message{{Role: User, Content: "hi"}}
+	tests := []struct {
+		name  string
+		model map[string]string
+		msgs  []*Message
+		want  string
+	}{
+		{name: "default", msgs: text, want: "groq/" + DEFAULT_CHAT_MODEL},
+		{name: "configured", model: map[string]string{config.ModelTypeChat: "llama-3.3-70b-versatile"}, msgs: text, want: "groq/llama-3.3-70b-versatile"},
+		{name: "unlimited model", model: map[string]string{config.ModelTypeChat: "gemma2-9b-it"}, msgs: text, want: "groq"},
+		{name: "default image", msgs: image, want: "groq/" + DEFAULT_CHAT_IMAGE_MODEL},
+		{name: "configured image", model: map[string]string{config.ModelTypeChatImage: "llama-3.2-90b-vision-preview"}, msgs: image, want: "groq/llama-3.2-90b-vision-preview"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewRateLimitProvider(nil, config.ProviderGroq, limits, "").(*rateLimitProvider)
+			cfg := &config.Config{Provider: config.ProviderGroq, Model: tt.model}
+			if got, _ := p.limit(chatModel(cfg, tt.msgs)); got != tt.want {
+				t.Errorf("limit key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	limit := &config.RateLimit{RequestsPerMinute: 60, TokensPerMinute: 6000}
+	tests := []struct {
+		name         string
+		bucket       bucket
+		wantRequests float64
+		wantTokens   float64
+	}{
+		{name: "half a minute", bucket: bucket{Requests: 0, Tokens: 0, Updated: time.Now().Add(-30 * time.Second)}, wantRequests: 30, wantTokens: 3000},
+		{name: "overdrawn", bucket: bucket{Requests: 0, Tokens: -6000, Updated: time.Now().Add(-30 * time.Second)}, wantRequests: 30, wantTokens: -3000},
+		{name: "capped", bucket: bucket{Requests: 50, Tokens: 5000, Updated: time.Now().Add(-time.Hour)}, wantRequests: 60, wantTokens: 6000},
+		{name: "clock went back", bucket: bucket{Requests: 5, Tokens: 500, Updated: time.Now().Add(time.Minute)}, wantRequests: 5, wantTokens: 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.bucket
+			refill(&b, limit)
+			// allow for time passed since bucket was set up
+			if math.Abs(b.Requests-tt.wantRequests) > 1 {
+				t.Errorf("requests = %v, want %v", b.Requests, tt.wantRequests)
+			}
+			if math.Abs(b.Tokens-tt.wantTokens) > 100 {
+				t.Errorf("tokens = %v, want %v", b.Tokens, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestRateLimitState(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "ratelimit.json")
+	limits := map[string]*config.RateLimit{"groq": {RequestsPerMinute: 2}}
+	cfg := &config.Config{Provider: config.ProviderGroq}
+	ctx := context.Background()
+
+	// separate instances stand for separate invocations sharing the state file
+	for i := 0; i < 2; i++ {
+		p := NewRateLimitProvider(nil, config.ProviderGroq, limits, statePath).(*rateLimitProvider)
+		key, limit := p.limit(chatModel(cfg, nil))
+		if err := p.acquire(ctx, key, limit, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state map[string]*bucket
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	b, ok := state["groq"]
+	if !ok {
+		t.Fatalf("state = %s, want bucket of groq", data)
+	}
+	if b.Requests > 0.1 {
+		t.Errorf("requests left = %v, want 0", b.Requests)
+	}
+
+	// third request has to wait for refill
+	p := NewRateLimitProvider(nil, config.ProviderGroq, limits, statePath).(*rateLimitProvider)
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := p.acquire(ctx, "groq", limits["groq"], 0); err != context.DeadlineExceeded {
+		t.Errorf("acquire = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// corrupted state is started over
+	if err := os.WriteFile(statePath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.acquire(context.Background(), "groq", limits["groq"], 0); err != nil {
+		t.Errorf("acquire with corrupted state = %v, want nil", err)
+	}
+}
@@ -0,0 +1,219 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/daulet/cmd/config"
+
+	core "github.com/cohere-ai/cohere-go/v2/core"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	DEFAULT_RETRY_MAX_ATTEMPTS    = 5
+	DEFAULT_RETRY_INITIAL_BACKOFF = time.Second
+	DEFAULT_RETRY_MAX_BACKOFF     = time.Minute
+	DEFAULT_RETRY_MAX_ELAPSED     = 5 * time.Minute
+)
+
+// Groq tells when to retry in the message, e.g. "Please try again in 6m6.125s."
+// or "Please try again in 719ms.", ms is matched before m so it isn't taken for minutes.
+var tryAgainIn = regexp.MustCompile(`try again in ((?:\d+(?:\.\d+)?(?:ms|h|m|s))+)`)
+
+// NewRetryProvider retries requests failed with rate limits, server errors and
+// connection resets, honoring provider hints on when to retry. Streams are
+// only retried if they fail to start, cfg can be nil to use defaults.
+func NewRetryProvider(p Provider, cfg *config.RetryConfig) Provider {
+	r := &retryProvider{
+		p:              p,
+		maxAttempts:    DEFAULT_RETRY_MAX_ATTEMPTS,
+		initialBackoff: DEFAULT_RETRY_INITIAL_BACKOFF,
+		maxBackoff:     DEFAULT_RETRY_MAX_BACKOFF,
+		maxElapsed:     DEFAULT_RETRY_MAX_ELAPSED,
+	}
+	if cfg != nil {
+		if cfg.MaxAttempts > 0 {
+			r.maxAttempts = cfg.MaxAttempts
+		}
+		if cfg.InitialBackoff > 0 {
+			r.initialBackoff = time.Duration(cfg.InitialBackoff)
+		}
+		if cfg.MaxBackoff > 0 {
+			r.maxBackoff = time.Duration(cfg.MaxBackoff)
+		}
+		if cfg.MaxElapsed > 0 {
+			r.maxElapsed = time.Duration(cfg.MaxElapsed)
+		}
+	}
+	return r
+}
+
+var _ Provider = (*retryProvider)(nil)
+
+type retryProvider struct {
+	p              Provider
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxElapsed     time.Duration
+}
+
+func (p *retryProvider) ListModels(ctx context.Context) ([]*Model, error) {
+	var res []*Model
+	err := p.retry(ctx, func() (err error) {
+		res, err = p.p.ListModels(ctx)
+		return err
+	})
+	return res, err
+}
+
+func (p *retryProvider) ListConnectors(ctx context.Context) ([]string, error) {
+	var res []string
+	err := p.retry(ctx, func() (err error) {
+		res, err = p.p.ListConnectors(ctx)
+		return err
+	})
+	return res, err
+}
+
+func (p *retryProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	var res io.Reader
+	err := p.retry(ctx, func() (err error) {
+		res, err = p.p.Stream(ctx, cfg, msgs, opts...)
+		return err
+	})
+	return res, err
+}
+
+func (p *retryProvider) Transcribe(ctx context.Context, cfg *config.Config, audio *AudioFile) ([]*AudioSegment, error) {
+	// audio has to be re-read on every attempt
+	data, err := io.ReadAll(audio.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var res []*AudioSegment
+	err = p.retry(ctx, func() (err error) {
+		res, err = p.p.Transcribe(ctx, cfg, &AudioFile{
+			FilePath: audio.FilePath,
+			Reader:   bytes.NewReader(data),
+		})
+		return err
+	})
+	return res, err
+}
+
+func (p *retryProvider) retry(ctx context.Context, fn func() error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		retryable, hint := classifyError(err)
+		if !retryable || attempt >= p.maxAttempts {
+			return err
+		}
+		wait := max(p.backoff(attempt), hint)
+		if time.Since(start)+wait > p.maxElapsed {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "attempt %d failed, retrying in %s: %v\n", attempt, wait.Round(time.Millisecond), err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff is exponential with jitter, so concurrent callers don't retry in lockstep.
+func (p *retryProvider) backoff(attempt int) time.Duration {
+	backoff := p.initialBackoff << (attempt - 1)
+	if backoff > p.maxBackoff || backoff <= 0 {
+		backoff = p.maxBackoff
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// IsTransient reports whether request failed due to rate limit or outage,
+// so it makes sense to retry it later or with another provider.
+func IsTransient(err error) bool {
+	retryable, _ := classifyError(err)
+	return retryable
+}
+
+// classifyError reports whether err is worth retrying and how long provider asked to wait.
+func classifyError(err error) (bool, time.Duration) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+	hint := retryHint(err.Error())
+
+	var (
+		apiErr *APIError
+		netErr net.Error
+	)
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > hint {
+		hint = apiErr.RetryAfter
+	}
+	if code := statusCode(err); code != 0 {
+		return retryableStatus(code), hint
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return true, hint
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true, hint
+	}
+	return false, 0
+}
+
+// statusCode of failed API request, zero when there was no response.
+func statusCode(err error) int {
+	var (
+		openaiAPIErr *openai.APIError
+		openaiReqErr *openai.RequestError
+		cohereAPIErr *core.APIError
+		apiErr       *APIError
+	)
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.StatusCode
+	case errors.As(err, &openaiAPIErr):
+		return openaiAPIErr.HTTPStatusCode
+	case errors.As(err, &openaiReqErr):
+		return openaiReqErr.HTTPStatusCode
+	case errors.As(err, &cohereAPIErr):
+		return cohereAPIErr.StatusCode
+	}
+	return 0
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500
+}
+
+func retryHint(msg string) time.Duration {
+	m := tryAgainIn.FindStringSubmatch(msg)
+	if m == nil || m[1] == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(m[1])
+	if err != nil {
+		return 0
+	}
+	return d
+}
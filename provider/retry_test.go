@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryHint(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want time.Duration
+	}{
+		{msg: "Please try again in 719ms.", want: 719 * time.Millisecond},
+		{msg: "Please try again in 6m6.125s.", want: 6*time.Minute + 6125*time.Millisecond},
+		{msg: "Please try again in 2m0s.", want: 2 * time.Minute},
+		{msg: "Please try again in 1.5s", want: 1500 * time.Millisecond},
+		{msg: "Please try again in 1h2m3s.", want: time.Hour + 2*time.Minute + 3*time.Second},
+		{msg: "Please try again in 5m", want: 5 * time.Minute},
+		{msg: "Please try again in 1s500ms.", want: 1500 * time.Millisecond},
+		{msg: "Please try again later.", want: 0},
+		{msg: "Rate limit reached", want: 0},
+	}
+	for _, tt := range tests {
+		if got := retryHint(tt.msg); got != tt.want {
+			t.Errorf("retryHint(%q) = %s, want %s", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+		hint      time.Duration
+	}{
+		{name: "rate limit with hint", err: &APIError{StatusCode: http.StatusTooManyRequests, Message: "Please try again in 719ms."}, retryable: true, hint: 719 * time.Millisecond},
+		{name: "retry after wins when longer", err: &APIError{StatusCode: http.StatusTooManyRequests, Message: "Please try again in 1s.", RetryAfter: 3 * time.Second}, retryable: true, hint: 3 * time.Second},
+		{name: "hint wins when longer", err: &APIError{StatusCode: http.StatusTooManyRequests, Message: "Please try again in 5s.", RetryAfter: time.Second}, retryable: true, hint: 5 * time.Second},
+		{name: "server error", err: &APIError{StatusCode: http.StatusBadGateway}, retryable: true},
+		{name: "request timeout", err: &APIError{StatusCode: http.StatusRequestTimeout}, retryable: true},
+		{name: "bad request", err: &APIError{StatusCode: http.StatusBadRequest, Message: "Please try again in 1s."}, retryable: false, hint: time.Second},
+		{name: "unauthorized", err: &APIError{StatusCode: http.StatusUnauthorized}, retryable: false},
+		{name: "wrapped", err: fmt.Errorf("stream: %w", &APIError{StatusCode: http.StatusServiceUnavailable}), retryable: true},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), retryable: true},
+		{name: "connection refused", err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), retryable: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, retryable: true},
+		{name: "canceled", err: context.Canceled, retryable: false},
+		{name: "deadline", err: fmt.Errorf("request: %w", context.DeadlineExceeded), retryable: false},
+		{name: "other", err: fmt.Errorf("invalid model"), retryable: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retryable, hint := classifyError(tt.err)
+			if retryable != tt.retryable || hint != tt.hint {
+				t.Errorf("classifyError(%v) = %v, %s, want %v, %s", tt.err, retryable, hint, tt.retryable, tt.hint)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package provider
+
+import "unicode/utf8"
+
+// SplitRunes splits s into pieces of at most n bytes without breaking runes,
+// a rune longer than n makes a piece of its own. Non-positive n keeps s whole.
+func SplitRunes(s string, n int) []string {
+	if n <= 0 {
+		return []string{s}
+	}
+	var pieces []string
+	for len(s) > n {
+		i := n
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		if i == 0 {
+			_, i = utf8.DecodeRuneInString(s)
+		}
+		pieces = append(pieces, s[:i])
+		s = s[i:]
+	}
+	if len(s) > 0 {
+		pieces = append(pieces, s)
+	}
+	return pieces
+}
@@ -0,0 +1,33 @@
+package provider
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitRunes(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want []string
+	}{
+		{name: "ascii", s: "abcdefg", n: 3, want: []string{"abc", "def", "g"}},
+		{name: "fits", s: "abc", n: 3, want: []string{"abc"}},
+		{name: "empty", s: "", n: 3, want: nil},
+		{name: "whole", s: "abc", n: 0, want: []string{"abc"}},
+		// é is 2 bytes, 世 is 3 bytes, 😀 is 4 bytes
+		{name: "two byte boundary", s: "aéb", n: 2, want: []string{"a", "é", "b"}},
+		{name: "three byte runes", s: "世界世界", n: 4, want: []string{"世", "界", "世", "界"}},
+		{name: "three byte runes fit", s: "世界世界", n: 6, want: []string{"世界", "世界"}},
+		{name: "rune longer than n", s: "😀😀", n: 2, want: []string{"😀", "😀"}},
+		{name: "mixed", s: "a😀b世", n: 5, want: []string{"a😀", "b世"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitRunes(tt.s, tt.n); !slices.Equal(got, tt.want) {
+				t.Errorf("SplitRunes(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+			}
+		})
+	}
+}
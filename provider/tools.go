@@ -0,0 +1,204 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/daulet/cmd/config"
+)
+
+// maxToolRounds limits how many times model can call tools before answering.
+const maxToolRounds = 10
+
+type ToolCall struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Arguments are JSON encoded
+	Arguments string `json:"arguments"`
+}
+
+type ToolDefinition struct {
+	Name        string
+	Description string
+	// Parameters is JSON schema of the arguments object
+	Parameters json.RawMessage
+	// Handler receives JSON encoded arguments and returns tool output for the model
+	Handler func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// ToolCaller is implemented by stream readers of providers supporting tools,
+// calls requested by the model are available once reader returns io.EOF.
+type ToolCaller interface {
+	ToolCalls() []*ToolCall
+}
+
+// NewToolProvider gives model access to tools: it executes tool calls requested
+// by the model and feeds results back until the model answers.
+func NewToolProvider(p Provider, tools []*ToolDefinition) Provider {
+	return &toolProvider{Provider: p, tools: tools}
+}
+
+var _ Provider = (*toolProvider)(nil)
+
+type toolProvider struct {
+	Provider
+	tools []*ToolDefinition
+}
+
+func (p *toolProvider) Stream(ctx context.Context, cfg *config.Config, msgs []*Message, opts ...StreamOption) (io.Reader, error) {
+	opts = append(opts, WithTools(p.tools...))
+	handlers := make(map[string]*ToolDefinition)
+	for _, tool := range newStreamOptions(opts).tools {
+		handlers[tool.Name] = tool
+	}
+	// rounds outlive this call, so they are cancelled by closing the reader
+	ctx, cancel := context.WithCancel(ctx)
+	// first round is synchronous so errors are returned to the caller
+	r, err := p.Provider.Stream(ctx, cfg, msgs, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	tr := &toolStreamReader{PipeReader: pr, cancel: cancel}
+	go func() {
+		defer cancel()
+		pw.CloseWithError(p.loop(ctx, cfg, msgs, opts, handlers, r, tr, pw))
+	}()
+	return tr, nil
+}
+
+var (
+	_ io.ReadCloser = (*toolStreamReader)(nil)
+	_ UsageReporter = (*toolStreamReader)(nil)
+)
+
+// toolStreamReader streams text of all rounds and sums their usage.
+type toolStreamReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+	usage  *Usage
+}
+
+func (r *toolStreamReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+func (r *toolStreamReader) Usage() *Usage {
+	return r.usage
+}
+
+func (r *toolStreamReader) add(usage *Usage) {
+	if usage == nil {
+		return
+	}
+	if r.usage == nil {
+		r.usage = &Usage{}
+	}
+	r.usage.add(usage)
+}
+
+func (p *toolProvider) loop(
+	ctx context.Context,
+	cfg *config.Config,
+	msgs []*Message,
+	opts []StreamOption,
+	handlers map[string]*ToolDefinition,
+	r io.Reader,
+	tr *toolStreamReader,
+	out io.Writer,
+) error {
+	// don't modify caller's history
+	msgs = append([]*Message(nil), msgs...)
+	for round := 0; ; round++ {
+		text := &textCollector{w: out}
+		_, err := io.Copy(text, r)
+		closeReader(r)
+		if err != nil {
+			return err
+		}
+		if reporter, ok := r.(UsageReporter); ok {
+			tr.add(reporter.Usage())
+		}
+		var calls []*ToolCall
+		if caller, ok := r.(ToolCaller); ok {
+			calls = caller.ToolCalls()
+		}
+		if len(calls) == 0 {
+			return nil
+		}
+		if round >= maxToolRounds {
+			return fmt.Errorf("model requested tools more than %d times", maxToolRounds)
+		}
+
+		msgs = append(msgs, &Message{
+			Role:      Assistant,
+			Content:   text.String(),
+			ToolCalls: calls,
+		})
+		for _, call := range calls {
+			msgs = append(msgs, &Message{
+				Role:       Tool,
+				Content:    callTool(ctx, handlers, call),
+				ToolCallID: call.ID,
+			})
+		}
+
+		r, err = p.Provider.Stream(ctx, cfg, msgs, opts...)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// callTool runs the handler, errors are reported to the model so it can recover.
+func callTool(ctx context.Context, handlers map[string]*ToolDefinition, call *ToolCall) string {
+	tool, ok := handlers[call.Name]
+	if !ok {
+		return fmt.Sprintf("error: unknown tool %s", call.Name)
+	}
+	args := json.RawMessage(call.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	res, err := tool.Handler(ctx, args)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return res
+}
+
+// textCollector writes through and remembers what was written.
+type textCollector struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (c *textCollector) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	return c.w.Write(p)
+}
+
+func (c *textCollector) String() string {
+	return string(c.buf)
+}
+
+// toolCallsAccumulator assembles streamed tool call deltas by their index.
+type toolCallsAccumulator struct {
+	calls []*ToolCall
+}
+
+func (a *toolCallsAccumulator) add(index int, id, name, args string) {
+	for len(a.calls) <= index {
+		a.calls = append(a.calls, &ToolCall{})
+	}
+	call := a.calls[index]
+	if id != "" {
+		call.ID = id
+	}
+	call.Name += name
+	call.Arguments += args
+}
@@ -0,0 +1,64 @@
+package provider
+
+import "time"
+
+type Usage struct {
+	// Provider that served the request, set when it may differ from configured one
+	Provider         string        `json:"provider,omitempty"`
+	Model            string        `json:"model,omitempty"`
+	PromptTokens     int           `json:"prompt_tokens"`
+	CompletionTokens int           `json:"completion_tokens"`
+	TimeToFirstToken time.Duration `json:"time_to_first_token"`
+}
+
+// UsageReporter is implemented by stream readers that report usage,
+// it is complete once reader returns io.EOF.
+type UsageReporter interface {
+	Usage() *Usage
+}
+
+// usageTracker is embedded by stream readers to implement UsageReporter.
+type usageTracker struct {
+	usage Usage
+	start time.Time
+}
+
+// newUsageTracker measures time to first token from start of the request.
+func newUsageTracker(model string, start time.Time) usageTracker {
+	return usageTracker{
+		usage: Usage{Model: model},
+		start: start,
+	}
+}
+
+func (t *usageTracker) Usage() *Usage {
+	return &t.usage
+}
+
+// generated records time to first token.
+func (t *usageTracker) generated(n int) {
+	if n > 0 && t.usage.TimeToFirstToken == 0 {
+		t.usage.TimeToFirstToken = time.Since(t.start)
+	}
+}
+
+// add accumulates usage of multiple requests, time to first token is of the first one.
+func (u *Usage) add(other *Usage) {
+	if u.Provider == "" {
+		u.Provider = other.Provider
+	}
+	if u.Model == "" {
+		u.Model = other.Model
+	}
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	if u.TimeToFirstToken == 0 {
+		u.TimeToFirstToken = other.TimeToFirstToken
+	}
+}
+
+// EstimateTokens roughly counts tokens of text, about 4 characters per token,
+// for budgeting before tokenizer of the model gets to count them.
+func EstimateTokens(text string) int {
+	return len(text)/4 + 1
+}